@@ -0,0 +1,17 @@
+package calculations
+
+import (
+	"encoding/binary"
+	"hash"
+)
+
+const chainTagV2 = "lsm-verification/chain/v2"
+
+// Every field is preceded by its length, so no two different
+// sequences of fields share an encoding.
+func writeField(h hash.Hash, field string) {
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(field)))
+	h.Write(length[:])
+	h.Write([]byte(field))
+}
@@ -0,0 +1,9 @@
+package calculations
+
+import "errors"
+
+var ErrUnknownMMRRoot = errors.New("MMR root is not in the last batch of this calculator nor resumed from its peaks")
+var ErrLeafNotCovered = errors.New("lseq is not covered by the MMR root")
+var ErrMalformedProof = errors.New("malformed inclusion proof")
+var ErrInclusionFailed = errors.New("inclusion proof does not match the root")
+var ErrUnsupportedScheme = errors.New("hash scheme is not supported by the calculator")
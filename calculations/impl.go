@@ -1,6 +1,7 @@
 package calculations
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"lsm-verification/models"
@@ -12,11 +13,21 @@ func CreateHashCalculator() HashCalculator {
 	return &hashCalculator{}
 }
 
-func (h *hashCalculator) CalculateBatch(items []models.DbItem, hashStart *string) ([]models.ValidateItem, error) {
+func (h *hashCalculator) CalculateBatch(ctx context.Context, items []models.DbItem, hashStart *string, scheme models.HashScheme) ([]models.ValidateItem, error) {
 	if len(items) == 0 {
 		return []models.ValidateItem{}, nil
 	}
 
+	var hashItem func(item *models.DbItem, prefixHash *string) string
+	switch scheme {
+	case models.HashSchemeConcat:
+		hashItem = hashPrefixWithDbItem
+	case models.HashSchemeLengthPrefixed, models.HashSchemeSignerKeys:
+		hashItem = hashPrefixWithEncodedDbItem
+	default:
+		return nil, ErrUnsupportedScheme
+	}
+
 	result := make([]models.ValidateItem, 0, len(items))
 	currentHash := ""
 	if hashStart != nil {
@@ -24,11 +35,15 @@ func (h *hashCalculator) CalculateBatch(items []models.DbItem, hashStart *string
 	}
 
 	for _, item := range items {
-		currentHash = hashPrefixWithDbItem(&item, &currentHash)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		currentHash = hashItem(&item, &currentHash)
 		validatesItem := models.ValidateItem{
 			Lseq:          nil,
 			LseqItemValid: item.Lseq,
 			Hash:          currentHash,
+			Scheme:        scheme,
 		}
 		result = append(result, validatesItem)
 	}
@@ -41,3 +56,13 @@ func hashPrefixWithDbItem(item *models.DbItem, prefixHash *string) string {
 	hash := sha256.Sum256([]byte(prefixWithlkv))
 	return hex.EncodeToString(hash[:])
 }
+
+func hashPrefixWithEncodedDbItem(item *models.DbItem, prefixHash *string) string {
+	hash := sha256.New()
+	writeField(hash, chainTagV2)
+	writeField(hash, *prefixHash)
+	writeField(hash, item.Lseq)
+	writeField(hash, item.Key)
+	writeField(hash, item.Value)
+	return hex.EncodeToString(hash.Sum(nil))
+}
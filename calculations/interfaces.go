@@ -1,7 +1,37 @@
 package calculations
 
-import "lsm-verification/models"
+import (
+	"context"
 
+	"lsm-verification/models"
+)
+
+// Calculation stops between items once the context is done
 type HashCalculator interface {
-	CalculateBatch(items []models.DbItem, hashStart *string) ([]models.ValidateItem, error)
+	CalculateBatch(ctx context.Context, items []models.DbItem, hashStart *string, scheme models.HashScheme) ([]models.ValidateItem, error)
+}
+
+type MMRCalculator interface {
+	HashCalculator
+
+	// Continues from the hash of the record, from the peaks stored with it
+	// or, without them, once a replay from the genesis reaches it. False if
+	// the history has to be replayed.
+	Resume(record *models.ValidateItem) bool
+}
+
+type MMRProver interface {
+	MMRCalculator
+
+	// Proves that the entry of the prover is covered by the given root
+	Prove(root string) (*InclusionProof, error)
+}
+
+// Sibling hashes lead from the leaf to the peak of its mountain,
+// the peaks are then bagged into the root.
+type InclusionProof struct {
+	LeafIndex uint64   `json:"leaf_index"`
+	LeafCount uint64   `json:"leaf_count"`
+	Path      []string `json:"path"`
+	Peaks     []string `json:"peaks"`
 }
@@ -0,0 +1,339 @@
+package calculations
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math/bits"
+	"sync"
+
+	"lsm-verification/models"
+)
+
+const (
+	mmrLeafTag = 0x00
+	mmrNodeTag = 0x01
+	mmrRootTag = 0x02
+)
+
+// Merkle Mountain Range over the replica entries. The hash of every
+// calculated item is the MMR root after appending that entry, so signing
+// it signs the whole history up to the entry.
+//
+// Leaves are always encoded with length-prefixed fields, so the only
+// supported schemes are HashSchemeLengthPrefixed and HashSchemeSignerKeys.
+//
+// Only the peaks are kept: the calculator continues from the roots of its
+// last batch, from the root the batch started from and from the root of a
+// record it resumed from. Every calculated item carries its peaks.
+type mmrCalculator struct {
+	mu sync.Mutex
+
+	current mmrState
+	// states of the roots the next batch can continue from
+	states map[string]mmrState
+	// root of a resumed record without peaks, kept once the replay from
+	// the genesis reaches it
+	resumed string
+
+	// leaf of the prover, nil for a calculator
+	proving *string
+	leaf    *uint64
+	// siblings[h] is the sibling of the node above the leaf at height h
+	siblings [][]byte
+}
+
+type mmrState struct {
+	size uint64
+	// peaks[h] is the peak of the mountain of height h, nil if the size
+	// has no such mountain
+	peaks [][]byte
+}
+
+func CreateMMRCalculator() MMRCalculator {
+	return &mmrCalculator{
+		states: make(map[string]mmrState),
+	}
+}
+
+// Calculator that records the path of the entry with the lseq
+func CreateMMRProver(lseq string) MMRProver {
+	return &mmrCalculator{
+		states:  make(map[string]mmrState),
+		proving: &lseq,
+	}
+}
+
+func (m *mmrCalculator) CalculateBatch(ctx context.Context, items []models.DbItem, hashStart *string, scheme models.HashScheme) ([]models.ValidateItem, error) {
+	if scheme != models.HashSchemeLengthPrefixed && scheme != models.HashSchemeSignerKeys {
+		return nil, ErrUnsupportedScheme
+	}
+	if len(items) == 0 {
+		return []models.ValidateItem{}, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	start := mmrState{}
+	if hashStart != nil {
+		state, exists := m.states[*hashStart]
+		if !exists {
+			return nil, ErrUnknownMMRRoot
+		}
+		start = state
+	}
+	m.current = start.copy()
+	if m.leaf != nil && *m.leaf >= m.current.size {
+		m.leaf, m.siblings = nil, nil
+	}
+
+	states := make(map[string]mmrState, len(items)+2)
+	if hashStart != nil {
+		states[*hashStart] = start
+	}
+	if state, exists := m.states[m.resumed]; exists {
+		states[m.resumed] = state
+	}
+	result := make([]models.ValidateItem, 0, len(items))
+	for _, item := range items {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if m.proving != nil && item.Lseq == *m.proving {
+			leaf := m.current.size
+			m.leaf, m.siblings = &leaf, nil
+		}
+		m.append(mmrLeafHash(&item))
+
+		root := m.current.root()
+		states[root] = m.current.copy()
+		result = append(result, models.ValidateItem{
+			Lseq:          nil,
+			LseqItemValid: item.Lseq,
+			Hash:          root,
+			Scheme:        scheme,
+			MMR:           m.current.encode(),
+		})
+	}
+	m.states = states
+
+	return result, nil
+}
+
+// The record is trusted as the calculator continues from its hash, its
+// peaks only have to bag into it
+func (m *mmrCalculator) Resume(record *models.ValidateItem) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.resumed = record.Hash
+	if _, exists := m.states[record.Hash]; exists {
+		return true
+	}
+	if record.MMR == nil || len(record.MMR.Peaks) != bits.OnesCount64(record.MMR.Size) {
+		return false
+	}
+	state := mmrState{size: record.MMR.Size, peaks: make([][]byte, bits.Len64(record.MMR.Size))}
+	next := 0
+	for height := len(state.peaks) - 1; height >= 0; height-- {
+		if state.size&(1<<height) == 0 {
+			continue
+		}
+		peak, err := hex.DecodeString(record.MMR.Peaks[next])
+		if err != nil || len(peak) != sha256.Size {
+			return false
+		}
+		state.peaks[height] = peak
+		next++
+	}
+	if state.root() != record.Hash {
+		return false
+	}
+	m.states[record.Hash] = state
+	return true
+}
+
+func (m *mmrCalculator) Prove(root string) (*InclusionProof, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, exists := m.states[root]
+	if !exists {
+		return nil, ErrUnknownMMRRoot
+	}
+	if m.leaf == nil || *m.leaf >= state.size {
+		return nil, ErrLeafNotCovered
+	}
+	leafIndex, leafCount := *m.leaf, state.size
+
+	_, _, height := mountainOf(leafIndex, leafCount)
+	path := make([]string, 0, height)
+	for level := 0; level < height; level++ {
+		// A root resumed from its peaks has no path
+		if level >= len(m.siblings) || m.siblings[level] == nil {
+			return nil, ErrLeafNotCovered
+		}
+		path = append(path, hex.EncodeToString(m.siblings[level]))
+	}
+
+	return &InclusionProof{
+		LeafIndex: leafIndex,
+		LeafCount: leafCount,
+		Path:      path,
+		Peaks:     state.encode().Peaks,
+	}, nil
+}
+
+// Merges the equal mountains like a binary increment of the size. The
+// prover keeps every node next to the path of its leaf.
+func (m *mmrCalculator) append(leaf []byte) {
+	node := leaf
+	index := m.current.size
+	for height := 0; ; height++ {
+		if height == len(m.current.peaks) {
+			m.current.peaks = append(m.current.peaks, nil)
+		}
+		m.keepSibling(height, index, node)
+		if m.current.size&(1<<height) == 0 {
+			m.current.peaks[height] = node
+			break
+		}
+		left := m.current.peaks[height]
+		m.keepSibling(height, index-1, left)
+		m.current.peaks[height] = nil
+		node = mmrNodeHash(left, node)
+		index >>= 1
+	}
+	m.current.size++
+}
+
+func (m *mmrCalculator) keepSibling(height int, index uint64, node []byte) {
+	if m.leaf == nil || index != (*m.leaf>>height)^1 {
+		return
+	}
+	for len(m.siblings) <= height {
+		m.siblings = append(m.siblings, nil)
+	}
+	m.siblings[height] = node
+}
+
+// The peaks are shared with the copy, appending only replaces them
+func (s mmrState) copy() mmrState {
+	return mmrState{size: s.size, peaks: append([][]byte(nil), s.peaks...)}
+}
+
+// Peaks from the highest mountain to the lowest
+func (s mmrState) ordered() [][]byte {
+	peaks := [][]byte{}
+	for height := len(s.peaks) - 1; height >= 0; height-- {
+		if s.size&(1<<height) != 0 {
+			peaks = append(peaks, s.peaks[height])
+		}
+	}
+	return peaks
+}
+
+func (s mmrState) root() string {
+	return mmrRoot(s.size, s.ordered())
+}
+
+func (s mmrState) encode() *models.MMRState {
+	peaks := []string{}
+	for _, peak := range s.ordered() {
+		peaks = append(peaks, hex.EncodeToString(peak))
+	}
+	return &models.MMRState{Size: s.size, Peaks: peaks}
+}
+
+// Returns the index of the peak whose mountain holds the leaf,
+// the index of the first leaf of the mountain and its height.
+func mountainOf(leafIndex, leafCount uint64) (int, uint64, int) {
+	var offset uint64
+	peak := 0
+	for height := bits.Len64(leafCount) - 1; height >= 0; height-- {
+		if leafCount&(1<<height) == 0 {
+			continue
+		}
+		if leafIndex < offset+(1<<height) {
+			return peak, offset, height
+		}
+		offset += 1 << height
+		peak++
+	}
+	return -1, 0, 0
+}
+
+func VerifyInclusion(item models.DbItem, proof *InclusionProof, root string) error {
+	if proof.LeafIndex >= proof.LeafCount || len(proof.Peaks) != bits.OnesCount64(proof.LeafCount) {
+		return ErrMalformedProof
+	}
+	peak, offset, height := mountainOf(proof.LeafIndex, proof.LeafCount)
+	if len(proof.Path) != height {
+		return ErrMalformedProof
+	}
+
+	node := mmrLeafHash(&item)
+	position := proof.LeafIndex - offset
+	for _, siblingHex := range proof.Path {
+		sibling, err := hex.DecodeString(siblingHex)
+		if err != nil {
+			return err
+		}
+		if position%2 == 0 {
+			node = mmrNodeHash(node, sibling)
+		} else {
+			node = mmrNodeHash(sibling, node)
+		}
+		position >>= 1
+	}
+
+	peaks := make([][]byte, 0, len(proof.Peaks))
+	for _, peakHex := range proof.Peaks {
+		decoded, err := hex.DecodeString(peakHex)
+		if err != nil {
+			return err
+		}
+		peaks = append(peaks, decoded)
+	}
+	if !bytes.Equal(node, peaks[peak]) {
+		return ErrInclusionFailed
+	}
+	if mmrRoot(proof.LeafCount, peaks) != root {
+		return ErrInclusionFailed
+	}
+
+	return nil
+}
+
+func mmrLeafHash(item *models.DbItem) []byte {
+	hash := sha256.New()
+	hash.Write([]byte{mmrLeafTag})
+	writeField(hash, item.Lseq)
+	writeField(hash, item.Key)
+	writeField(hash, item.Value)
+	return hash.Sum(nil)
+}
+
+func mmrNodeHash(left, right []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte{mmrNodeTag})
+	hash.Write(left)
+	hash.Write(right)
+	return hash.Sum(nil)
+}
+
+// The root commits to the number of leaves as well as to all the peaks.
+func mmrRoot(leafCount uint64, peaks [][]byte) string {
+	hash := sha256.New()
+	hash.Write([]byte{mmrRootTag})
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], leafCount)
+	hash.Write(length[:])
+	for _, peak := range peaks {
+		hash.Write(peak)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,239 @@
+package calculations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/bits"
+	"testing"
+
+	"lsm-verification/models"
+)
+
+func mmrItems(count int) []models.DbItem {
+	items := make([]models.DbItem, 0, count)
+	for i := 1; i <= count; i++ {
+		items = append(items, models.DbItem{
+			Lseq:  fmt.Sprintf("%020d@1", i),
+			Key:   fmt.Sprintf("key%d", i),
+			Value: fmt.Sprintf("value%d", i),
+		})
+	}
+	return items
+}
+
+// Root of a perfect tree, computed recursively from the leaves
+func referenceTree(leaves [][]byte) []byte {
+	if len(leaves) == 1 {
+		return leaves[0]
+	}
+	half := len(leaves) / 2
+	return mmrNodeHash(referenceTree(leaves[:half]), referenceTree(leaves[half:]))
+}
+
+// Root of the leaves without the incremental levels of the calculator
+func referenceRoot(items []models.DbItem) string {
+	leaves := make([][]byte, 0, len(items))
+	for idx := range items {
+		leaves = append(leaves, mmrLeafHash(&items[idx]))
+	}
+	peaks := [][]byte{}
+	offset := 0
+	for height := bits.Len(uint(len(leaves))) - 1; height >= 0; height-- {
+		if len(leaves)&(1<<height) == 0 {
+			continue
+		}
+		peaks = append(peaks, referenceTree(leaves[offset:offset+1<<height]))
+		offset += 1 << height
+	}
+	return mmrRoot(uint64(len(leaves)), peaks)
+}
+
+func TestMMRRoots(t *testing.T) {
+	items := mmrItems(33)
+	calculated, err := CreateMMRCalculator().CalculateBatch(context.Background(), items, nil, models.HashSchemeLengthPrefixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calculated) != len(items) {
+		t.Fatalf("got %d roots for %d items", len(calculated), len(items))
+	}
+	seen := map[string]bool{}
+	for idx, item := range calculated {
+		if item.LseqItemValid != items[idx].Lseq {
+			t.Errorf("root %d is of lseq %s, expected %s", idx, item.LseqItemValid, items[idx].Lseq)
+		}
+		if expected := referenceRoot(items[:idx+1]); item.Hash != expected {
+			t.Errorf("root after %d leaves is %s, expected %s", idx+1, item.Hash, expected)
+		}
+		if seen[item.Hash] {
+			t.Errorf("root after %d leaves repeats an earlier root", idx+1)
+		}
+		seen[item.Hash] = true
+	}
+}
+
+func TestMMRContinuesFromItsRoots(t *testing.T) {
+	ctx := context.Background()
+	items := mmrItems(20)
+	calculator := CreateMMRCalculator()
+	whole, err := calculator.CalculateBatch(ctx, items, nil, models.HashSchemeLengthPrefixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Continuing from an earlier root drops the leaves after it
+	for _, split := range []int{1, 7, 8, 19} {
+		rest, err := calculator.CalculateBatch(ctx, items[split:], &whole[split-1].Hash, models.HashSchemeLengthPrefixed)
+		if err != nil {
+			t.Fatalf("split %d: %v", split, err)
+		}
+		for idx, item := range rest {
+			if item.Hash != whole[split+idx].Hash {
+				t.Fatalf("split %d: root after %d leaves differs", split, split+idx+1)
+			}
+		}
+	}
+
+	unknown := "00"
+	if _, err := calculator.CalculateBatch(ctx, items, &unknown, models.HashSchemeLengthPrefixed); !errors.Is(err, ErrUnknownMMRRoot) {
+		t.Errorf("unknown root: got %v, expected %v", err, ErrUnknownMMRRoot)
+	}
+	if _, err := calculator.CalculateBatch(ctx, items, nil, models.HashSchemeConcat); !errors.Is(err, ErrUnsupportedScheme) {
+		t.Errorf("concat scheme: got %v, expected %v", err, ErrUnsupportedScheme)
+	}
+}
+
+func TestMMRResumesFromPeaks(t *testing.T) {
+	ctx := context.Background()
+	items := mmrItems(20)
+	calculator := CreateMMRCalculator()
+	first, err := calculator.CalculateBatch(ctx, items[:11], nil, models.HashSchemeLengthPrefixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := calculator.CalculateBatch(ctx, items[11:], &first[10].Hash, models.HashSchemeLengthPrefixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the roots of the last batch and its start are kept
+	if _, err := calculator.CalculateBatch(ctx, items[6:], &first[5].Hash, models.HashSchemeLengthPrefixed); !errors.Is(err, ErrUnknownMMRRoot) {
+		t.Errorf("root of an earlier batch: got %v, expected %v", err, ErrUnknownMMRRoot)
+	}
+
+	resumed := CreateMMRCalculator()
+	if !resumed.Resume(&first[10]) {
+		t.Fatal("calculator doesn't resume from the peaks of the record")
+	}
+	rest, err := resumed.CalculateBatch(ctx, items[11:], &first[10].Hash, models.HashSchemeLengthPrefixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx := range rest {
+		if rest[idx].Hash != second[idx].Hash {
+			t.Fatalf("root after %d leaves differs", 12+idx)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(record *models.ValidateItem)
+	}{
+		{"without peaks", func(record *models.ValidateItem) { record.MMR = nil }},
+		{"changed peak", func(record *models.ValidateItem) { record.MMR.Peaks[0] = record.MMR.Peaks[1] }},
+		{"missing peak", func(record *models.ValidateItem) { record.MMR.Peaks = record.MMR.Peaks[1:] }},
+		{"other size", func(record *models.ValidateItem) { record.MMR.Size = 13 }},
+		{"malformed peak", func(record *models.ValidateItem) { record.MMR.Peaks[0] = "peak" }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			record := first[10]
+			mmr := *record.MMR
+			mmr.Peaks = append([]string(nil), mmr.Peaks...)
+			record.MMR = &mmr
+			test.mutate(&record)
+			if CreateMMRCalculator().Resume(&record) {
+				t.Fatal("calculator resumes from peaks that don't match the root")
+			}
+		})
+	}
+}
+
+func TestMMRInclusionProofs(t *testing.T) {
+	items := mmrItems(17)
+	// Every leaf against every root covering it
+	for leaf := range items {
+		prover := CreateMMRProver(items[leaf].Lseq)
+		roots, err := prover.CalculateBatch(context.Background(), items, nil, models.HashSchemeLengthPrefixed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for size := 1; size <= len(items); size++ {
+			root := roots[size-1].Hash
+			proof, err := prover.Prove(root)
+			if size <= leaf {
+				if !errors.Is(err, ErrLeafNotCovered) {
+					t.Errorf("leaf %d after the root of %d: got %v, expected %v", leaf, size, err, ErrLeafNotCovered)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("leaf %d of %d: %v", leaf, size, err)
+			}
+			if proof.LeafIndex != uint64(leaf) || proof.LeafCount != uint64(size) {
+				t.Fatalf("leaf %d of %d: proof is of leaf %d of %d", leaf, size, proof.LeafIndex, proof.LeafCount)
+			}
+			if err := VerifyInclusion(items[leaf], proof, root); err != nil {
+				t.Fatalf("leaf %d of %d: %v", leaf, size, err)
+			}
+		}
+		if _, err := prover.Prove("00"); !errors.Is(err, ErrUnknownMMRRoot) {
+			t.Errorf("unknown root: got %v, expected %v", err, ErrUnknownMMRRoot)
+		}
+	}
+}
+
+func TestMMRInclusionRejectsTampering(t *testing.T) {
+	items := mmrItems(13)
+	const leaf = 5
+	prover := CreateMMRProver(items[leaf].Lseq)
+	roots, err := prover.CalculateBatch(context.Background(), items, nil, models.HashSchemeLengthPrefixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := roots[len(roots)-1].Hash
+
+	tests := []struct {
+		name   string
+		mutate func(item *models.DbItem, proof *InclusionProof, root *string)
+		err    error
+	}{
+		{"changed value", func(item *models.DbItem, _ *InclusionProof, _ *string) { item.Value = "forged" }, ErrInclusionFailed},
+		{"changed key", func(item *models.DbItem, _ *InclusionProof, _ *string) { item.Key = "forged" }, ErrInclusionFailed},
+		{"other lseq", func(item *models.DbItem, _ *InclusionProof, _ *string) { item.Lseq = items[leaf+1].Lseq }, ErrInclusionFailed},
+		{"earlier root", func(_ *models.DbItem, _ *InclusionProof, root *string) { *root = roots[leaf+1].Hash }, ErrInclusionFailed},
+		{"changed sibling", func(_ *models.DbItem, proof *InclusionProof, _ *string) { proof.Path[0] = proof.Peaks[0] }, ErrInclusionFailed},
+		{"changed peak", func(_ *models.DbItem, proof *InclusionProof, _ *string) {
+			proof.Peaks[len(proof.Peaks)-1] = proof.Path[0]
+		}, ErrInclusionFailed},
+		{"other leaf index", func(_ *models.DbItem, proof *InclusionProof, _ *string) { proof.LeafIndex = leaf + 1 }, ErrInclusionFailed},
+		{"leaf index out of range", func(_ *models.DbItem, proof *InclusionProof, _ *string) { proof.LeafIndex = proof.LeafCount }, ErrMalformedProof},
+		{"other leaf count", func(_ *models.DbItem, proof *InclusionProof, _ *string) { proof.LeafCount = 12 }, ErrMalformedProof},
+		{"short path", func(_ *models.DbItem, proof *InclusionProof, _ *string) { proof.Path = proof.Path[1:] }, ErrMalformedProof},
+		{"missing peak", func(_ *models.DbItem, proof *InclusionProof, _ *string) { proof.Peaks = proof.Peaks[1:] }, ErrMalformedProof},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proof, err := prover.Prove(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			item, testRoot := items[leaf], root
+			test.mutate(&item, proof, &testRoot)
+			if err := VerifyInclusion(item, proof, testRoot); !errors.Is(err, test.err) {
+				t.Errorf("got %v, expected %v", err, test.err)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
@@ -12,29 +13,336 @@ import (
 const (
 	RunModeValidation = "Validation"
 	RunModeSign       = "Sign"
+	// Keeps validating new entries as they are signed
+	RunModeMonitor = "Monitor"
+	// Signs another replica, the records are stored in the attestation replica
+	RunModeAttest = "Attest"
+	// Writes the inclusion proof of proof.lseq against the last signed MMR root
+	RunModeProve = "Prove"
+	// Checks a proof file against the signed root and the entry in the database
+	RunModeVerifyProof = "VerifyProof"
+)
+
+const (
+	HashCalculatorChain = "chain"
+	HashCalculatorMMR   = "mmr"
 )
 
 type Config struct {
-	RunMode     string `yaml:"run_mode,omitempty"`
-	SkipErrors  bool   `yaml:"skip_errors,omitempty"`
-	SignTimeout int    `yaml:"sign_timeout,omitempty"`
-	Env         Env
-	Db          Db `yaml:"db,omitempty"`
+	RunMode string `yaml:"run_mode,omitempty"`
+	// Deprecated: ignored, replaced by failure_policy
+	SkipErrors  bool `yaml:"skip_errors,omitempty"`
+	SignTimeout int  `yaml:"sign_timeout,omitempty"`
+	// Seconds the current batch gets to be written on SIGINT or SIGTERM
+	ShutdownTimeout int `yaml:"shutdown_timeout,omitempty"`
+	// Seconds between the coverage logs of the signer
+	CoverageInterval int            `yaml:"coverage_interval,omitempty"`
+	HashCalculator   string         `yaml:"hash_calculator,omitempty"`
+	Keyring          []KeyringEntry `yaml:"keyring,omitempty"`
+	KeyRotationDays  int            `yaml:"key_rotation_days,omitempty"`
+	RevocationList   string         `yaml:"revocation_list,omitempty"`
+	// IDs of keyring keys that may revoke any key, even when revoked themselves
+	RevocationAuthorities []string   `yaml:"revocation_authorities,omitempty"`
+	Checkpoint            Checkpoint `yaml:"checkpoint,omitempty"`
+	Workers               Workers    `yaml:"workers,omitempty"`
+	// Failure class to the rule for its errors
+	FailurePolicy map[string]FailureRule `yaml:"failure_policy,omitempty"`
+	Report        Report                 `yaml:"report,omitempty"`
+	// Validates only these lseqs instead of the whole replica
+	ValidationRange ValidationRange `yaml:"validation_range,omitempty"`
+	// File with the last verified record, validation resumes from it
+	VerifierState string  `yaml:"verifier_state,omitempty"`
+	Monitor       Monitor `yaml:"monitor,omitempty"`
+	// Replicas validated concurrently in one run instead of dbReplicaID
+	Replicas []Replica `yaml:"replicas,omitempty"`
+	Metrics  Metrics   `yaml:"metrics,omitempty"`
+	// Validation records of dbReplicaID are stored in another replica
+	Attestation Attestation `yaml:"attestation,omitempty"`
+	// Keys starting with it are reserved for validation records, "_v_" by default
+	ValidationPrefix string `yaml:"validation_prefix,omitempty"`
+	// Keeps the records of the signer apart from other signers of the replica
+	Namespace string `yaml:"namespace,omitempty"`
+	// Namespaces of the other signers of the replica, their keys are not
+	// hashed as entries. The default namespace always is a signer.
+	SignerNamespaces []string `yaml:"signer_namespaces,omitempty"`
+	// Independent signers of dbReplicaID, k of them have to agree
+	Quorum Quorum `yaml:"quorum,omitempty"`
+	Proof  Proof  `yaml:"proof,omitempty"`
+	Env    Env
+	Db     Db `yaml:"db,omitempty"`
 }
 type Env struct {
-	Db  EnvDb
-	Rsa Rsa
+	Db   EnvDb
+	Keys Keys
 }
 type EnvDb struct {
 	ServerAddress string
 	ReplicaID     int32
 }
-type Rsa struct {
+
+// PEM-encoded RSA, Ed25519 or ECDSA P-256 keys
+type Keys struct {
 	PublicKey  string
 	PrivateKey string
+	// Signs the rotation record together with the new private key
+	PreviousPrivateKey string
+}
+
+// Trusted public key, valid for lseqs in (valid_after, valid_until]
+type KeyringEntry struct {
+	PublicKeyFile string `yaml:"public_key_file"`
+	ValidAfter    string `yaml:"valid_after,omitempty"`
+	ValidUntil    string `yaml:"valid_until,omitempty"`
+}
+
+// Signs one record every 'every' entries or 'interval' seconds instead of
+// every entry, both zero means every entry is signed
+type Checkpoint struct {
+	Every       int  `yaml:"every,omitempty"`
+	Interval    int  `yaml:"interval,omitempty"`
+	StoreHashes bool `yaml:"store_hashes,omitempty"`
+}
+
+// Action is abort, retry or skip, retries only apply to retry
+type FailureRule struct {
+	Action  string `yaml:"action"`
+	Retries int    `yaml:"retries,omitempty"`
+}
+
+// Validation starts from the signed record at or before 'from', or from the
+// genesis without it, and stops at the first signed record at or after 'to'
+type ValidationRange struct {
+	From string `yaml:"from,omitempty"`
+	To   string `yaml:"to,omitempty"`
+}
+
+// The monitor validates new entries every 'interval' seconds and reports the
+// signer as lagging when an entry stays unsigned for 'max_lag' seconds,
+// zero disables the lag check
+type Monitor struct {
+	Interval int `yaml:"interval,omitempty"`
+	MaxLag   int `yaml:"max_lag,omitempty"`
+}
+
+// Replica validated together with the others. Empty fields fall back to the
+// env variables and the top-level settings.
+type Replica struct {
+	ReplicaID int32  `yaml:"replica_id"`
+	Address   string `yaml:"address,omitempty"`
+	// Failover addresses of the replica, tried after address
+	Endpoints     []string       `yaml:"endpoints,omitempty"`
+	PublicKeyFile string         `yaml:"public_key_file,omitempty"`
+	Keyring       []KeyringEntry `yaml:"keyring,omitempty"`
+	VerifierState string         `yaml:"verifier_state,omitempty"`
+	// Sign mode, the keys default to privateKey and previousPrivateKey
+	PrivateKeyFile         string  `yaml:"private_key_file,omitempty"`
+	PreviousPrivateKeyFile string  `yaml:"previous_private_key_file,omitempty"`
+	BatchSize              *uint32 `yaml:"batch_size,omitempty"`
+	SignTimeout            int     `yaml:"sign_timeout,omitempty"`
+	// Replica storing the records of this one, for an attestor and its validators
+	Attestation *Attestation `yaml:"attestation,omitempty"`
+	Namespace   string       `yaml:"namespace,omitempty"`
+}
+
+// Replica of an attestor storing its validation records of another replica.
+// The attestor connects to a server owning it, since records are appended to
+// the own replica of the server.
+type Attestation struct {
+	ReplicaID *int32 `yaml:"replica_id,omitempty"`
+}
+
+// History is accepted up to the last lseq verified by k of the signers
+type Quorum struct {
+	K       int      `yaml:"k,omitempty"`
+	Signers []Signer `yaml:"signers,omitempty"`
+}
+
+// Signer with its own key, its records are read from its namespace in its
+// attestation replica, or in the replica itself without it
+type Signer struct {
+	Name          string         `yaml:"name"`
+	PublicKeyFile string         `yaml:"public_key_file,omitempty"`
+	Keyring       []KeyringEntry `yaml:"keyring,omitempty"`
+	Attestation   *Attestation   `yaml:"attestation,omitempty"`
+	Namespace     string         `yaml:"namespace,omitempty"`
+}
+
+// Inclusion proof of a single lseq, written by Prove and read by VerifyProof
+type Proof struct {
+	Lseq string `yaml:"lseq,omitempty"`
+	Path string `yaml:"path,omitempty"`
+}
+
+// Serves the signer metrics of every replica on /metrics, disabled without
+// an address
+type Metrics struct {
+	Address string `yaml:"address,omitempty"`
+}
+
+// Validation result written as json or junit, to stdout without a path.
+// No report without a format.
+type Report struct {
+	Format string `yaml:"format,omitempty"`
+	Path   string `yaml:"path,omitempty"`
+}
+
+// Goroutines computing and verifying signatures, zero means one per CPU.
+// Queue bounds the items waiting for a worker.
+type Workers struct {
+	Sign   int `yaml:"sign,omitempty"`
+	Verify int `yaml:"verify,omitempty"`
+	Queue  int `yaml:"queue,omitempty"`
 }
 type Db struct {
 	BatchSize *uint32 `yaml:"batch_size,omitempty"`
+	// Deadline of a single request in seconds
+	RpcTimeout int `yaml:"rpc_timeout,omitempty"`
+	// Failover addresses of the same replica, tried after dbServerAddress
+	Endpoints []string  `yaml:"endpoints,omitempty"`
+	Retry     Retry     `yaml:"retry,omitempty"`
+	Keepalive Keepalive `yaml:"keepalive,omitempty"`
+	TLS       TLS       `yaml:"tls,omitempty"`
+}
+
+// Reads failing with a transient error are retried with exponential backoff
+type Retry struct {
+	MaxAttempts    int `yaml:"max_attempts,omitempty"`
+	InitialBackoff int `yaml:"initial_backoff_ms,omitempty"`
+	MaxBackoff     int `yaml:"max_backoff_ms,omitempty"`
+}
+
+// Pings an idle connection every 'time' seconds, disabled when zero
+type Keepalive struct {
+	Time    int `yaml:"time,omitempty"`
+	Timeout int `yaml:"timeout,omitempty"`
+}
+
+// PEM files, without ca_file the system roots are used. A client certificate
+// and key enable mutual TLS, server_name overrides the name expected in the
+// server certificate.
+type TLS struct {
+	Enabled    bool   `yaml:"enabled,omitempty"`
+	CAFile     string `yaml:"ca_file,omitempty"`
+	CertFile   string `yaml:"cert_file,omitempty"`
+	KeyFile    string `yaml:"key_file,omitempty"`
+	ServerName string `yaml:"server_name,omitempty"`
+}
+
+// Signatures of the key are untrusted for lseqs starting from revoked_from
+// or when made at or after revoked_at (RFC 3339). The signing time is set by
+// the signer, only revoked_from holds against a backdating key.
+type Revocation struct {
+	KeyID       string `yaml:"key_id"`
+	RevokedFrom string `yaml:"revoked_from,omitempty"`
+	RevokedAt   string `yaml:"revoked_at,omitempty"`
+	Reason      string `yaml:"reason,omitempty"`
+}
+
+type RevocationList struct {
+	Revocations []Revocation `yaml:"revocations"`
+}
+
+func LoadRevocationList(path string) (RevocationList, error) {
+	var list RevocationList
+	yamlFile, err := ioutil.ReadFile(path)
+	if err != nil {
+		return list, err
+	}
+	err = yaml.Unmarshal(yamlFile, &list)
+	return list, err
+}
+
+// Only Sign and Attest write to the database, the other modes audit it
+func (c Config) Writes() bool {
+	return c.RunMode == RunModeSign || c.RunMode == RunModeAttest
+}
+
+// Config of a single replica of the list
+func (c Config) ForReplica(replica Replica) (Config, error) {
+	replicaCfg := c
+	replicaCfg.Replicas = nil
+	replicaCfg.Env.Db.ReplicaID = replica.ReplicaID
+	if replica.Address != "" {
+		replicaCfg.Env.Db.ServerAddress = replica.Address
+		replicaCfg.Db.Endpoints = nil
+	}
+	if replica.Endpoints != nil {
+		replicaCfg.Db.Endpoints = replica.Endpoints
+	}
+	if replica.PublicKeyFile != "" {
+		publicKey, err := ioutil.ReadFile(replica.PublicKeyFile)
+		if err != nil {
+			return replicaCfg, err
+		}
+		replicaCfg.Env.Keys.PublicKey = string(publicKey)
+		replicaCfg.Keyring = nil
+	}
+	if replica.Keyring != nil {
+		replicaCfg.Keyring = replica.Keyring
+	}
+	if replica.PrivateKeyFile != "" {
+		privateKey, err := ioutil.ReadFile(replica.PrivateKeyFile)
+		if err != nil {
+			return replicaCfg, err
+		}
+		replicaCfg.Env.Keys.PrivateKey = string(privateKey)
+		replicaCfg.Env.Keys.PreviousPrivateKey = ""
+	}
+	if replica.PreviousPrivateKeyFile != "" {
+		previousKey, err := ioutil.ReadFile(replica.PreviousPrivateKeyFile)
+		if err != nil {
+			return replicaCfg, err
+		}
+		replicaCfg.Env.Keys.PreviousPrivateKey = string(previousKey)
+	}
+	if replica.BatchSize != nil {
+		replicaCfg.Db.BatchSize = replica.BatchSize
+	}
+	if replica.SignTimeout > 0 {
+		replicaCfg.SignTimeout = replica.SignTimeout
+	}
+	if replica.Attestation != nil {
+		replicaCfg.Attestation = *replica.Attestation
+	}
+	if replica.Namespace != "" {
+		replicaCfg.Namespace = replica.Namespace
+	}
+	if replica.VerifierState != "" {
+		replicaCfg.VerifierState = replica.VerifierState
+	} else if c.VerifierState != "" {
+		// A state file holds a single replica
+		replicaCfg.VerifierState = fmt.Sprintf("%s.%d", c.VerifierState, replica.ReplicaID)
+	}
+	return replicaCfg, nil
+}
+
+// Config validating the records of a single signer of the quorum
+func (c Config) ForSigner(signer Signer) (Config, error) {
+	signerCfg := c
+	signerCfg.Quorum = Quorum{}
+	signerCfg.Env.Keys.PublicKey = ""
+	signerCfg.Keyring = signer.Keyring
+	if signer.PublicKeyFile != "" {
+		publicKey, err := ioutil.ReadFile(signer.PublicKeyFile)
+		if err != nil {
+			return signerCfg, err
+		}
+		signerCfg.Env.Keys.PublicKey = string(publicKey)
+	}
+	signerCfg.Attestation = Attestation{}
+	if signer.Attestation != nil {
+		signerCfg.Attestation = *signer.Attestation
+	}
+	signerCfg.Namespace = signer.Namespace
+	// Every signer of the quorum leaves out the keys of the others
+	signerCfg.SignerNamespaces = append([]string{}, c.SignerNamespaces...)
+	for _, other := range c.Quorum.Signers {
+		signerCfg.SignerNamespaces = append(signerCfg.SignerNamespaces, other.Namespace)
+	}
+	if c.VerifierState != "" {
+		signerCfg.VerifierState = c.VerifierState + "." + signer.Name
+	}
+	return signerCfg, nil
 }
 
 func loadEnvVar(envVar string) string {
@@ -45,6 +353,18 @@ func loadEnvVar(envVar string) string {
 	return variable
 }
 
+// Keys of any algorithm, the rsa names of RSA-only keys are deprecated aliases
+func lookupKeyEnvVar(envVar, deprecated string) (string, bool) {
+	if variable, exists := os.LookupEnv(envVar); exists {
+		return variable, true
+	}
+	variable, exists := os.LookupEnv(deprecated)
+	if exists {
+		log.Printf("Warning: env variable %s is deprecated, use %s instead\n", deprecated, envVar)
+	}
+	return variable, exists
+}
+
 func LoadConfig(path string) Config {
 	yamlFile, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -55,21 +375,38 @@ func LoadConfig(path string) Config {
 	if err != nil {
 		log.Fatalf("Unmarshal: %v", err)
 	}
-	config.Env.Db.ServerAddress = loadEnvVar("dbServerAddress")
-	replicaId, err := strconv.Atoi(loadEnvVar("dbReplicaID"))
-	if err != nil {
-		log.Fatalln("Failed to convert replica id")
+	if config.SkipErrors {
+		log.Println("Warning: skip_errors is ignored, use failure_policy instead")
 	}
-	config.Env.Db.ReplicaID = int32(replicaId)
-	config.Env.Rsa.PublicKey = loadEnvVar("rsaPublicKey")
-	if privateKey, exists := os.LookupEnv("rsaPrivateKey"); exists {
-		config.Env.Rsa.PrivateKey = privateKey
+	if len(config.Replicas) == 0 {
+		config.Env.Db.ServerAddress = loadEnvVar("dbServerAddress")
+		replicaId, err := strconv.Atoi(loadEnvVar("dbReplicaID"))
+		if err != nil {
+			log.Fatalln("Failed to convert replica id")
+		}
+		config.Env.Db.ReplicaID = int32(replicaId)
 	} else {
-		if config.RunMode != RunModeValidation {
-			log.Fatalln("rsaPrivateKey key not found, trying to start in mode: ", config.RunMode)
+		// Defaults of the replicas, the replica id comes from the list
+		config.Env.Db.ServerAddress = os.Getenv("dbServerAddress")
+	}
+	publicKey, exists := lookupKeyEnvVar("publicKey", "rsaPublicKey")
+	if !exists && len(config.Keyring) == 0 && len(config.Replicas) == 0 && len(config.Quorum.Signers) == 0 {
+		log.Fatalln("Env variable not found", "publicKey")
+	}
+	config.Env.Keys.PublicKey = publicKey
+	if privateKey, exists := lookupKeyEnvVar("privateKey", "rsaPrivateKey"); exists {
+		config.Env.Keys.PrivateKey = privateKey
+	} else {
+		// The replicas of the list may have their own keys
+		if config.RunMode != RunModeValidation && config.RunMode != RunModeMonitor &&
+			config.RunMode != RunModeProve && config.RunMode != RunModeVerifyProof && len(config.Replicas) == 0 {
+			log.Fatalln("privateKey key not found, trying to start in mode: ", config.RunMode)
+		}
+		if config.RunMode != RunModeSign {
+			log.Println("Starting in validation mode")
 		}
-		log.Println("Starting in validation mode")
 	}
+	config.Env.Keys.PreviousPrivateKey, _ = lookupKeyEnvVar("previousPrivateKey", "rsaPreviousPrivateKey")
 	log.Println("Config loaded")
 	return config
 }
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"log"
+
+	"lsm-verification/models"
+	"lsm-verification/proto"
+)
+
+// A signed lseq is its own anchor. Otherwise, every batch or checkpoint the
+// signer writes moves the last validated lseq forward, so its history lists
+// signed records in lseq order and the latest one before the lseq is found
+// without reading the entries. Values that go back or have no verified
+// record are not the signer's and are passed over.
+func (d *dbApi) ReadAnchor(ctx context.Context, lseq string) (*models.ValidateItem, error) {
+	record, err := d.readSignedRecord(ctx, lseq)
+	if err != ErrLastValidatedIsMissing && err != ErrLastValidatedIsNotSigned {
+		return record, err
+	}
+
+	log.Println("Looking for a signed record before lseq", lseq)
+	limit := d.batchSize * recordPageFactor
+	key := d.recordKeys.lastValidated
+	candidates := []string{}
+	var cursor *string
+	for done := false; !done; {
+		eventsRequest := &proto.EventsRequest{
+			ReplicaId: d.recordReplicaId,
+			Lseq:      cursor,
+			Key:       &key,
+			Limit:     &limit,
+		}
+		rpcCtx, cancel := d.rpcContext(ctx)
+		events, err := d.client.GetReplicaEvents(rpcCtx, eventsRequest)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if len(events.Items) == 0 {
+			break
+		}
+
+		for _, item := range events.Items {
+			if item == nil {
+				return nil, ErrEmptyItem
+			}
+			if item.Value > lseq {
+				done = true
+				break
+			}
+			if len(candidates) == 0 || item.Value > candidates[len(candidates)-1] {
+				candidates = append(candidates, item.Value)
+			}
+		}
+		cursor = &events.Items[len(events.Items)-1].Lseq
+	}
+
+	for idx := len(candidates) - 1; idx >= 0; idx-- {
+		anchor, err := d.readSignedRecord(ctx, candidates[idx])
+		if err == nil || isStatusError(err) {
+			return anchor, err
+		}
+		log.Printf("Warning: skipping the last validated lseq '%s' without a verified record: %v\n", candidates[idx], err)
+	}
+	log.Println("No signed record before the lseq, starting from the genesis")
+	return nil, nil
+}
+
+func (d *dbApi) ReadSignedRecord(ctx context.Context, lseq string) (*models.ValidateItem, error) {
+	record, err := d.readSignedRecord(ctx, lseq)
+	if err == ErrLastValidatedIsMissing {
+		return nil, nil
+	}
+	return record, err
+}
+
+func (d *dbApi) HasEntry(ctx context.Context, lseq string) (bool, error) {
+	entry, err := d.ReadEntry(ctx, lseq)
+	return entry != nil, err
+}
+
+func (d *dbApi) ReadEntry(ctx context.Context, lseq string) (*models.DbItem, error) {
+	limit := uint32(1)
+	seekRequest := &proto.SeekGetRequest{
+		Lseq:  lseq,
+		Limit: &limit,
+	}
+	rpcCtx, cancel := d.rpcContext(ctx)
+	defer cancel()
+	items, err := d.client.SeekGet(rpcCtx, seekRequest)
+	if err != nil {
+		return nil, err
+	}
+	if len(items.Items) == 0 || items.Items[0] == nil || items.Items[0].Lseq != lseq {
+		return nil, nil
+	}
+	return &models.DbItem{
+		Lseq:  lseq,
+		Key:   items.Items[0].Key,
+		Value: items.Items[0].Value,
+	}, nil
+}
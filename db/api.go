@@ -2,48 +2,105 @@ package db
 
 import (
 	"context"
-	"crypto/rsa"
 	"log"
 	"lsm-verification/config"
 	"lsm-verification/models"
 	"lsm-verification/proto"
 	"lsm-verification/signature"
 	"strings"
+	"time"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const defaultBatchSize = 100
 
 type dbApi struct {
-	privateKey *rsa.PrivateKey
-	publicKey  *rsa.PublicKey
-	replicaId  int32
-	conn       *grpc.ClientConn
-	client     proto.LSeqDatabaseClient
-	batchSize  uint32
+	signer    signature.Signer
+	keyring   *signature.Keyring
+	replicaId int32
+	// replica storing the validation records, another one for an attestor
+	recordReplicaId int32
+	recordKeys      recordKeys
+	conn            *grpc.ClientConn
+	client          proto.LSeqDatabaseClient
+	batchSize       uint32
+	rpcTimeout      time.Duration
+	records         *recordIndex
+	signPool        *workerPool
+	// verifies the records of a batch
+	verifyPool *workerPool
+	// key state the signer writes on StartSigning
+	previousSigner   signature.Signer
+	localRevocations []config.Revocation
+	// keys that may revoke any key
+	authorities     []string
+	published       []*revocationRecord
+	pending         *pendingRevocations
+	rotations       []*rotationRecord
+	keyRotationDays int
+	// last validated lseq the signer read or wrote, empty before
+	lastValidated string
+	// checked on the start or the first write of the signer
+	ownsRecordReplica bool
 }
 
-func CreateDbState(cfg config.Config) (DbState, error) {
+// Extra dial options are applied after the defaults, e.g. to connect
+// to an in-process server.
+func CreateDbState(ctx context.Context, cfg config.Config, dialOptions ...grpc.DialOption) (DbState, error) {
+	keys, err := loadKeys(cfg)
+	if err != nil {
+		return nil, err
+	}
+	recordKeys, err := newRecordKeys(cfg.ValidationPrefix, cfg.Namespace, cfg.SignerNamespaces, cfg.Env.Db.ReplicaID, recordReplicaId(cfg))
+	if err != nil {
+		return nil, err
+	}
+
 	return createDbApi(
-		cfg.Env.Db.ServerAddress,
+		ctx,
+		append([]string{cfg.Env.Db.ServerAddress}, cfg.Db.Endpoints...),
 		cfg.Env.Db.ReplicaID,
-		cfg.Db.BatchSize,
-		cfg.Env.Rsa.PublicKey,
-		cfg.Env.Rsa.PrivateKey,
+		recordReplicaId(cfg),
+		recordKeys,
+		cfg.Db,
+		keys,
+		cfg.KeyRotationDays,
+		cfg.Workers,
+		dialOptions...,
 	)
 }
 
+// An attestor stores its records in its own replica
+func recordReplicaId(cfg config.Config) int32 {
+	if cfg.Attestation.ReplicaID != nil {
+		return *cfg.Attestation.ReplicaID
+	}
+	return cfg.Env.Db.ReplicaID
+}
+
 func createDbApi(
-	addr string,
+	ctx context.Context,
+	addrs []string,
 	replicaId int32,
-	batchSize *uint32,
-	publicKeyEnvVariable string,
-	privateKeyEnvVariable string,
+	recordReplicaId int32,
+	recordKeys recordKeys,
+	dbCfg config.Db,
+	keys *keys,
+	keyRotationDays int,
+	workers config.Workers,
+	dialOptions ...grpc.DialOption,
 ) (*dbApi, error) {
 	log.Println("Dialing GRPC")
-	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	creds, err := transportCredentials(dbCfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	target, transport := transportOptions(addrs, dbCfg)
+	defaults := append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, transport...)
+	conn, err := grpc.Dial(target, append(defaults, dialOptions...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -52,43 +109,74 @@ func createDbApi(
 	client := proto.NewLSeqDatabaseClient(conn)
 
 	var finalBatchSize uint32 = defaultBatchSize
-	if batchSize != nil && *batchSize != 0 {
-		finalBatchSize = *batchSize
+	if dbCfg.BatchSize != nil && *dbCfg.BatchSize != 0 {
+		finalBatchSize = *dbCfg.BatchSize
 	}
 	log.Printf("Set the database batch size as %d\n", finalBatchSize)
+	rpcTimeout := time.Duration(dbCfg.RpcTimeout) * time.Second
+	if rpcTimeout <= 0 {
+		rpcTimeout = defaultRpcTimeout
+	}
 
-	log.Println("Trying to load the public key")
-	publicKey, err := loadPublicKey(publicKeyEnvVariable)
-	if err != nil {
-		if err == ErrEmptyKey {
-			log.Println("Warning: public key is not set, can only certify history")
-		} else {
-			return nil, err
-		}
+	d := &dbApi{
+		signer:           keys.signer,
+		keyring:          keys.keyring,
+		replicaId:        replicaId,
+		recordReplicaId:  recordReplicaId,
+		recordKeys:       recordKeys,
+		conn:             conn,
+		client:           client,
+		batchSize:        finalBatchSize,
+		rpcTimeout:       rpcTimeout,
+		records:          newRecordIndex(),
+		signPool:         newWorkerPool(workers.Sign, workers.Queue),
+		verifyPool:       newWorkerPool(workers.Verify, workers.Queue),
+		previousSigner:   keys.previousSigner,
+		localRevocations: keys.revocations,
+		authorities:      keys.authorities,
+		keyRotationDays:  keyRotationDays,
 	}
 
-	log.Println("Trying to load the private key")
-	privateKey, err := loadPrivateKey(privateKeyEnvVariable)
+	published, revocations, err := d.loadRevocations(ctx, keys.revocations)
 	if err != nil {
-		if err == ErrEmptyKey {
-			log.Println("Warning: private key is not set, can only verify history")
-		} else {
-			return nil, err
-		}
+		conn.Close()
+		return nil, err
 	}
-
-	if publicKey == nil && privateKey == nil {
-		return nil, ErrNoKeys
+	rotations, err := d.loadRotations(ctx, revocations)
+	if err != nil {
+		conn.Close()
+		return nil, err
 	}
+	d.skipRevocations(revocations)
+	d.published, d.pending, d.rotations = published, revocations, rotations
 
-	return &dbApi{
-		privateKey: privateKey,
-		publicKey:  publicKey,
-		replicaId:  replicaId,
-		conn:       conn,
-		client:     client,
-		batchSize:  finalBatchSize,
-	}, nil
+	return d, nil
+}
+
+// The only writes besides the records: the rotation to the signing key and
+// the local revocations missing from the database
+func (d *dbApi) StartSigning(ctx context.Context) error {
+	if d.signer == nil {
+		return ErrNoPrivateKey
+	}
+	if err := d.checkRecordReplica(ctx); err != nil {
+		return err
+	}
+	if d.previousSigner != nil {
+		rotations, err := d.rotateKey(ctx, d.previousSigner, d.rotations)
+		if err != nil {
+			return err
+		}
+		d.rotations = rotations
+		if err := d.applyRevocations(d.pending); err != nil {
+			return err
+		}
+	}
+	if err := d.publishRevocations(ctx, d.published, d.localRevocations); err != nil {
+		return err
+	}
+	d.checkKeyAge(d.rotations, d.keyRotationDays)
+	return nil
 }
 
 func (d *dbApi) CloseConnection() {
@@ -96,7 +184,7 @@ func (d *dbApi) CloseConnection() {
 	d.conn.Close()
 }
 
-func (d *dbApi) ReadBatch(startLseq *string) ([]models.DbItem, error) {
+func (d *dbApi) ReadBatch(ctx context.Context, startLseq *string) ([]models.DbItem, error) {
 	eventsRequest := &proto.EventsRequest{
 		ReplicaId: d.replicaId,
 		Lseq:      startLseq,
@@ -104,7 +192,9 @@ func (d *dbApi) ReadBatch(startLseq *string) ([]models.DbItem, error) {
 	}
 
 	log.Println("Requesting a DBItem batch from the database")
-	dbItemsObj, err := d.client.GetReplicaEvents(context.Background(), eventsRequest)
+	rpcCtx, cancel := d.rpcContext(ctx)
+	defer cancel()
+	dbItemsObj, err := d.client.GetReplicaEvents(rpcCtx, eventsRequest)
 	if err != nil {
 		return nil, err
 	}
@@ -118,36 +208,92 @@ func (d *dbApi) ReadBatch(startLseq *string) ([]models.DbItem, error) {
 			return nil, ErrEmptyItem
 		}
 
-		if isValidationKey(item.Key) {
-			log.Println("Skipping a validation-specific key", item.Key)
-			continue
+		// The schemes before models.HashSchemeSignerKeys skip by the value,
+		// the current one verifies the value of the signer keys only
+		reserved := models.NotReserved
+		if d.recordKeys.isLegacyValidationItem(item.Key, item.Value) {
+			validation, err := d.isValidationItem(ctx, item.Key, item.Value)
+			if err != nil {
+				return nil, err
+			}
+			if validation {
+				log.Println("Skipping a validation-specific key", item.Key)
+				continue
+			}
+			reserved = models.ReservedByValue
+		}
+		if reserved == models.NotReserved && strings.HasPrefix(item.Key, d.recordKeys.prefix) {
+			log.Println("Warning: key in the validation prefix is not a validation record, hashing it as an entry", item.Key)
 		}
 
 		result = append(
 			result,
 			models.DbItem{
-				Lseq:  item.Lseq,
-				Key:   item.Key,
-				Value: item.Value,
+				Lseq:     item.Lseq,
+				Key:      item.Key,
+				Value:    item.Value,
+				Reserved: reserved,
 			},
 		)
 	}
 	log.Println("Finished preprocessing the batch")
 
 	if len(result) == 0 && len(dbItems) > 0 {
-		return d.ReadBatch(&dbItems[len(dbItems)-1].Lseq)
+		return d.ReadBatch(ctx, &dbItems[len(dbItems)-1].Lseq)
 	}
 	return result, nil
 }
 
-func (d *dbApi) getLastValue(key string) (*proto.Value, error) {
+// Every event of the replica with the key, oldest first
+func (d *dbApi) readEventsByKey(ctx context.Context, key string) ([]*proto.DBItems_DbItem, error) {
+	var startLseq *string
+	result := []*proto.DBItems_DbItem{}
+	for {
+		eventsRequest := &proto.EventsRequest{
+			ReplicaId: d.recordReplicaId,
+			Lseq:      startLseq,
+			Key:       &key,
+			Limit:     &d.batchSize,
+		}
+		rpcCtx, cancel := d.rpcContext(ctx)
+		events, err := d.client.GetReplicaEvents(rpcCtx, eventsRequest)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if len(events.Items) == 0 {
+			return result, nil
+		}
+
+		for _, item := range events.Items {
+			if item == nil {
+				return nil, ErrEmptyItem
+			}
+			result = append(result, item)
+		}
+		startLseq = &events.Items[len(events.Items)-1].Lseq
+	}
+}
+
+func (d *dbApi) getLastValue(ctx context.Context, key string) (*proto.Value, error) {
+	return d.getReplicaValue(ctx, key, d.recordReplicaId)
+}
+
+// Nil if the replica has no value of the key
+func (d *dbApi) getReplicaValue(ctx context.Context, key string, replicaId int32) (*proto.Value, error) {
 	replicaKey := &proto.ReplicaKey{
 		Key:       key,
-		ReplicaId: &d.replicaId,
+		ReplicaId: &replicaId,
 	}
 
 	log.Println("Requesting the last value based on a key from the database", replicaKey)
-	val, err := d.client.GetValue(context.Background(), replicaKey)
+	rpcCtx, cancel := d.rpcContext(ctx)
+	defer cancel()
+	val, err := d.client.GetValue(rpcCtx, replicaKey)
+	if status.Code(err) == codes.NotFound {
+		log.Println("The key is not present in the database")
+		return nil, nil
+	}
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -157,120 +303,330 @@ func (d *dbApi) getLastValue(key string) (*proto.Value, error) {
 	return val, nil
 }
 
-func (d *dbApi) ReadBatchValidated(lseqs []string) ([]models.ValidateItem, error) {
-	result := make([]models.ValidateItem, 0, len(lseqs))
+// The key is picked from the keyring by the key ID of the record and has
+// to be valid for its lseq. The algorithm recorded next to the signature
+// has to match the key, so a record can't be downgraded to a weaker algorithm.
+// Returns the keyring entry of the key that made the signature.
+func (d *dbApi) verifyRecord(record *validationRecord, lseq string) (*signature.KeyringEntry, error) {
+	if d.keyring.Len() == 0 {
+		return nil, ErrNoPublicKey
+	}
 
-	log.Println("Validating lseqs")
-	for _, lseq := range lseqs {
-		val, err := d.getLastValue(createValidationKey(lseq))
+	var entries []*signature.KeyringEntry
+	if record.Version == recordVersionLegacy {
+		entries = d.keyring.Covering(lseq)
+	} else {
+		if record.Lseq != lseq || record.ReplicaID != d.replicaId {
+			return nil, ErrRecordMismatch
+		}
+		entry, err := d.keyring.Find(record.KeyID, lseq)
 		if err != nil {
-			return result, err
+			return nil, err
 		}
-		if val == nil {
-			log.Println("Got an unvalidated lseq, skipping the rest")
-			return result, nil
+		entries = []*signature.KeyringEntry{entry}
+	}
+
+	digest, err := record.signedDigest()
+	if err != nil {
+		return nil, err
+	}
+
+	err = signature.ErrKeyOutOfRange
+	for _, entry := range entries {
+		if record.SignatureAlgorithm != entry.Verifier.Algorithm() {
+			err = ErrAlgorithmMismatch
+			continue
+		}
+		if err = entry.Verifier.Verify(record.Signature, digest); err == nil {
+			return entry, nil
+		}
+	}
+	return nil, err
+}
+
+// Cryptographically valid signatures of a revoked key are still untrusted.
+// Hash records carry no signature and are returned as they are.
+func (d *dbApi) verifiedItem(record *validationRecord, lseq string, val *proto.Value) (*models.ValidateItem, error) {
+	if record.Kind == recordKindHash {
+		if record.Lseq != lseq || record.ReplicaID != d.replicaId {
+			return nil, ErrRecordMismatch
 		}
-		log.Println("Loaded a validated lseq")
+		return &models.ValidateItem{
+			Lseq:          &val.Lseq,
+			LseqItemValid: lseq,
+			Hash:          record.Hash,
+			Scheme:        record.Scheme,
+			HashOnly:      true,
+		}, nil
+	}
+
+	log.Println("Verifying the signature")
+	entry, err := d.verifyRecord(record, lseq)
+	if err != nil {
+		return nil, err
+	}
 
-		log.Println("Splitting its value into the hash and the signature")
-		hash, signed, err := splitHashAndSignature(val.Value)
+	var signedAt *time.Time
+	if record.SignedAt != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, record.SignedAt)
 		if err != nil {
-			return result, err
+			return nil, ErrIncorrectValidationValue
 		}
+		signedAt = &parsed
+	}
+	revoked := entry.Revoked(lseq, signedAt)
+	if revoked {
+		log.Printf("Warning: lseq %s is signed by the revoked key %s\n", lseq, entry.Verifier.KeyID())
+	}
 
-		log.Println("Verifying the signature")
-		if err := signature.VerifySignature(signed, hash, d.publicKey); err != nil {
-			return result, err
+	return &models.ValidateItem{
+		Lseq:          &val.Lseq,
+		LseqItemValid: lseq,
+		Hash:          record.Hash,
+		Scheme:        record.Scheme,
+		KeyID:         entry.Verifier.KeyID(),
+		Signature:     record.Signature,
+		Revoked:       revoked,
+		MMR:           record.MMR,
+	}, nil
+}
+
+func (d *dbApi) ReadBatchValidated(ctx context.Context, lseqs []string) ([]models.ValidateItem, error) {
+	result := make([]models.ValidateItem, 0, len(lseqs))
+
+	values, err := d.readRecords(ctx, lseqs)
+	if err != nil {
+		return result, err
+	}
+
+	log.Println("Validating lseqs")
+	items := make([]*models.ValidateItem, len(lseqs))
+	done, err := d.verifyPool.run(ctx, len(lseqs), func(idx int) error {
+		val := values[idx]
+		if val == nil {
+			log.Println("Got an unvalidated lseq")
+			return nil
 		}
 
-		result = append(
-			result,
-			models.ValidateItem{
-				Lseq:          &val.Lseq,
-				LseqItemValid: lseq,
-				Hash:          hash,
-			},
-		)
+		record, err := parseValidationRecord(val.Value)
+		if err != nil {
+			return &RecordError{Lseq: lseqs[idx], Err: err}
+		}
+		if items[idx], err = d.verifiedItem(record, lseqs[idx], val); err != nil {
+			return &RecordError{Lseq: lseqs[idx], Err: err}
+		}
+		return nil
+	})
+
+	for _, item := range items[:done] {
+		if item != nil {
+			result = append(result, *item)
+		}
+	}
+	if err != nil {
+		return result, err
 	}
 	log.Println("Successfully validated all lseqs")
 
 	return result, nil
 }
 
-func (d *dbApi) GetLastValidated() (*models.ValidateItem, error) {
-	validationValue, err := d.getLastValue(lastValidated)
-	if err != nil || validationValue == nil {
-		if strings.Contains(err.Error(), "NotFound") {
-			return nil, nil
-		}
-		return nil, err
-	}
-	log.Println("Loaded the last validated lseq object")
-
-	lastValidatedValue, err := d.getLastValue(createValidationKey(validationValue.Value))
+// Verified signed record of the lseq
+func (d *dbApi) readSignedRecord(ctx context.Context, lseq string) (*models.ValidateItem, error) {
+	recordValue, err := d.getLastValue(ctx, d.recordKeys.record(lseq))
 	if err != nil {
 		return nil, err
 	}
-	if lastValidatedValue == nil {
+	if recordValue == nil {
 		return nil, ErrLastValidatedIsMissing
 	}
 	log.Println("Loaded the hash and signature object for the last validated lseq")
 
-	log.Println("Splitting its value into the hash and the signature")
-	hash, signed, err := splitHashAndSignature(lastValidatedValue.Value)
+	log.Println("Parsing the validation record")
+	record, err := parseValidationRecord(recordValue.Value)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Println("Verifying the signature")
-	if err := signature.VerifySignature(signed, hash, d.publicKey); err != nil {
+	result, err := d.verifiedItem(record, lseq, recordValue)
+	if err != nil {
 		return nil, err
 	}
-
-	result := &models.ValidateItem{
-		Lseq:          &lastValidatedValue.Lseq,
-		LseqItemValid: validationValue.Value,
-		Hash:          hash,
+	if result.HashOnly {
+		return nil, ErrLastValidatedIsNotSigned
 	}
-	log.Println("Constructed the last validated item")
-
 	return result, nil
 }
 
-func (d *dbApi) put(key, value string) error {
+func (d *dbApi) put(ctx context.Context, key, value string) error {
 	putRequest := &proto.PutRequest{
 		Key:   key,
 		Value: value,
 	}
 
 	log.Println("Requesting to append to the database", putRequest)
-	_, err := d.client.Put(context.Background(), putRequest)
+	rpcCtx, cancel := d.rpcContext(ctx)
+	defer cancel()
+	lseq, err := d.client.Put(rpcCtx, putRequest)
 	if err != nil {
 		return err
 	}
 	log.Println("Appended to the database")
 
+	// Put appends to the own replica of the server, the first write to an
+	// empty record replica has to be found there
+	if !d.ownsRecordReplica {
+		written, err := d.getLastValue(ctx, key)
+		if err != nil {
+			return err
+		}
+		if written == nil || written.Lseq != lseq.Lseq {
+			return ErrWrongRecordReplica
+		}
+		d.ownsRecordReplica = true
+	}
+
 	return nil
 }
 
-func (d *dbApi) signAndPut(item models.ValidateItem) error {
-	log.Println("Signing the hash")
-	signed, err := signature.Sign(item.Hash, d.privateKey)
+// Put appends to the replica of the server, so the signer refuses to start
+// unless the server owns the record replica. An empty record replica can't be
+// checked, the first write is.
+func (d *dbApi) checkRecordReplica(ctx context.Context) error {
+	owned, known, err := d.ownsReplica(ctx, d.recordReplicaId)
 	if err != nil {
 		return err
 	}
+	if known {
+		if !owned {
+			return ErrWrongRecordReplica
+		}
+		d.ownsRecordReplica = true
+		return nil
+	}
+	if d.recordReplicaId != d.replicaId {
+		// The records would be written next to the attested entries
+		attested, _, err := d.ownsReplica(ctx, d.replicaId)
+		if err != nil {
+			return err
+		}
+		if attested {
+			return ErrWrongRecordReplica
+		}
+	}
+	log.Println("Warning: record replica is empty, the server is checked on the first write", d.recordReplicaId)
+	return nil
+}
+
+// The key of the last event of the replica is read without a replica, from
+// the own replica of the server: the server owns the replica if it is the
+// same event. Lseqs are compared as they are, not parsed. Unknown for an
+// empty replica.
+func (d *dbApi) ownsReplica(ctx context.Context, replicaId int32) (bool, bool, error) {
+	rpcCtx, cancel := d.rpcContext(ctx)
+	defer cancel()
+	head, err := d.client.SyncGet_(rpcCtx, &proto.SyncGetRequest{ReplicaId: replicaId})
+	if status.Code(err) == codes.NotFound {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	entry, err := d.ReadEntry(ctx, head.Lseq)
+	if err != nil || entry == nil {
+		return false, false, err
+	}
 
-	return d.put(createValidationKey(item.LseqItemValid), joinHashAndSignature(item.Hash, signed))
+	own, err := d.client.GetValue(rpcCtx, &proto.ReplicaKey{Key: entry.Key})
+	if status.Code(err) == codes.NotFound {
+		return false, true, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	return own.Lseq == head.Lseq, true, nil
 }
 
-func (d *dbApi) PutBatch(items []models.ValidateItem) error {
+// Returns the encoded signed record of the item
+func (d *dbApi) signRecord(item *models.ValidateItem, kind string) (string, error) {
+	if d.signer == nil {
+		return "", ErrNoPrivateKey
+	}
+
+	record := newValidationRecord(item, d.replicaId, kind, d.signer.Algorithm(), d.signer.KeyID())
+	digest, err := record.signedDigest()
+	if err != nil {
+		return "", err
+	}
+
+	log.Println("Signing the record")
+	record.Signature, err = d.signer.Sign(digest)
+	if err != nil {
+		return "", err
+	}
+
+	return record.encode()
+}
+
+// Records are signed in parallel and appended in the lseq order,
+// nothing is appended if any signature fails. A cancelled ctx aborts the
+// writes, the signer cancels it the shutdown timeout after a shutdown.
+// Only the last record keeps the MMR peaks, the next batch starts from it.
+func (d *dbApi) PutBatch(ctx context.Context, items []models.ValidateItem) error {
+	encoded := make([]string, len(items))
+	_, err := d.signPool.run(ctx, len(items), func(idx int) error {
+		item := items[idx]
+		if idx < len(items)-1 {
+			item.MMR = nil
+		}
+		var err error
+		encoded[idx], err = d.signRecord(&item, recordKindEntry)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	log.Println("Appending a batch to the database")
-	for _, item := range items {
-		if err := d.signAndPut(item); err != nil {
+	for idx, item := range items {
+		if err := d.put(ctx, d.recordKeys.record(item.LseqItemValid), encoded[idx]); err != nil {
 			return err
 		}
 	}
 
-	log.Println("Updating the last validated lseq in the database")
-	return d.put(lastValidated, items[len(items)-1].LseqItemValid)
+	return d.putLastValidated(ctx, items[len(items)-1].LseqItemValid)
+}
+
+func (d *dbApi) PutCheckpoint(ctx context.Context, items []models.ValidateItem, storeHashes bool) error {
+	checkpoint := items[len(items)-1]
+	encoded, err := d.signRecord(&checkpoint, recordKindCheckpoint)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if storeHashes {
+		log.Println("Appending the running hashes to the database")
+		for _, item := range items[:len(items)-1] {
+			hashRecord, err := newHashRecord(&item, d.replicaId).encode()
+			if err != nil {
+				return err
+			}
+			if err := d.put(ctx, d.recordKeys.record(item.LseqItemValid), hashRecord); err != nil {
+				return err
+			}
+		}
+	}
+
+	log.Println("Appending a checkpoint to the database", checkpoint.LseqItemValid)
+	if err := d.put(ctx, d.recordKeys.record(checkpoint.LseqItemValid), encoded); err != nil {
+		return err
+	}
+
+	return d.putLastValidated(ctx, checkpoint.LseqItemValid)
 }
@@ -0,0 +1,13 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+const defaultRpcTimeout = 30 * time.Second
+
+// Every RPC gets its own deadline
+func (d *dbApi) rpcContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, d.rpcTimeout)
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"log"
+
+	"lsm-verification/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Entries after the lseq, from the genesis without it: the first one, their
+// number and the number of all events after the lseq. Validation records and
+// service keys are events but not entries.
+func (d *dbApi) ReadTail(ctx context.Context, lseq *string) (string, int64, int64, error) {
+	log.Println("Counting the entries after the last signed lseq")
+	first := ""
+	count, events := int64(0), int64(0)
+	err := d.scanEvents(ctx, lseq, "", func(item *proto.DBItems_DbItem, validation bool) {
+		events++
+		if validation {
+			return
+		}
+		if first == "" {
+			first = item.Lseq
+		}
+		count++
+	})
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return first, count, events, nil
+}
+
+func (d *dbApi) CountEntries(ctx context.Context, from *string, to string) (int64, error) {
+	log.Println("Counting the entries up to the last signed lseq")
+	count := int64(0)
+	err := d.scanEvents(ctx, from, to, func(_ *proto.DBItems_DbItem, validation bool) {
+		if !validation {
+			count++
+		}
+	})
+	return count, err
+}
+
+// Visits the events after from up to to, or to the end of the replica if
+// to is empty
+func (d *dbApi) scanEvents(ctx context.Context, from *string, to string, visit func(item *proto.DBItems_DbItem, validation bool)) error {
+	limit := d.batchSize * recordPageFactor
+	cursor := from
+	for {
+		eventsRequest := &proto.EventsRequest{
+			ReplicaId: d.replicaId,
+			Lseq:      cursor,
+			Limit:     &limit,
+		}
+		rpcCtx, cancel := d.rpcContext(ctx)
+		events, err := d.client.GetReplicaEvents(rpcCtx, eventsRequest)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if len(events.Items) == 0 {
+			return nil
+		}
+
+		for _, item := range events.Items {
+			if item == nil {
+				return ErrEmptyItem
+			}
+			if to != "" && item.Lseq > to {
+				return nil
+			}
+			validation, err := d.isValidationItem(ctx, item.Key, item.Value)
+			if err != nil {
+				return err
+			}
+			visit(item, validation)
+		}
+		cursor = &events.Items[len(events.Items)-1].Lseq
+	}
+}
+
+// Last event of the replica, empty for an empty replica
+func (d *dbApi) ReadHead(ctx context.Context) (string, error) {
+	rpcCtx, cancel := d.rpcContext(ctx)
+	defer cancel()
+	head, err := d.client.SyncGet_(rpcCtx, &proto.SyncGetRequest{ReplicaId: d.replicaId})
+	if status.Code(err) == codes.NotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return head.Lseq, nil
+}
@@ -1,23 +1,112 @@
 package db
 
 import (
-	"crypto/rsa"
+	"log"
+	"os"
 
+	"lsm-verification/config"
 	"lsm-verification/signature"
 )
 
-func loadPublicKey(keyString string) (*rsa.PublicKey, error) {
+type keys struct {
+	signer         signature.Signer
+	previousSigner signature.Signer
+	keyring        *signature.Keyring
+	revocations    []config.Revocation
+	authorities    []string
+}
+
+func loadPublicKey(keyString string) (signature.Verifier, error) {
 	if len(keyString) == 0 {
 		return nil, ErrEmptyKey
 	}
 
-	return signature.LoadPublicKey(keyString)
+	return signature.LoadVerifier(keyString)
 }
 
-func loadPrivateKey(keyString string) (*rsa.PrivateKey, error) {
+func loadPrivateKey(keyString string) (signature.Signer, error) {
 	if len(keyString) == 0 {
 		return nil, ErrEmptyKey
 	}
 
-	return signature.LoadPrivateKey(keyString)
+	return signature.LoadSigner(keyString)
+}
+
+func loadKeys(cfg config.Config) (*keys, error) {
+	result := &keys{keyring: signature.NewKeyring()}
+
+	log.Println("Trying to load the keyring")
+	for _, entry := range cfg.Keyring {
+		contents, err := os.ReadFile(entry.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		verifier, err := loadPublicKey(string(contents))
+		if err != nil {
+			return nil, err
+		}
+		result.keyring.Add(verifier, entry.ValidAfter, entry.ValidUntil)
+	}
+
+	log.Println("Trying to load the public key")
+	verifier, err := loadPublicKey(cfg.Env.Keys.PublicKey)
+	if err != nil {
+		if err == ErrEmptyKey {
+			log.Println("Warning: public key is not set, can only certify history")
+		} else {
+			return nil, err
+		}
+	}
+	if verifier != nil {
+		if _, exists := result.keyring.Entry(verifier.KeyID()); !exists {
+			result.keyring.Add(verifier, "", "")
+		}
+	}
+
+	if cfg.Writes() {
+		log.Println("Trying to load the private key")
+		result.signer, err = loadPrivateKey(cfg.Env.Keys.PrivateKey)
+		if err != nil {
+			if err == ErrEmptyKey {
+				log.Println("Warning: private key is not set, can only verify history")
+			} else {
+				return nil, err
+			}
+		}
+
+		result.previousSigner, err = loadPrivateKey(cfg.Env.Keys.PreviousPrivateKey)
+		if err != nil && err != ErrEmptyKey {
+			return nil, err
+		}
+	} else if cfg.Env.Keys.PrivateKey != "" {
+		// A validator never writes to the replica it audits
+		log.Println("Private key is not used in mode: ", cfg.RunMode)
+	}
+	if result.previousSigner != nil {
+		if _, exists := result.keyring.Entry(result.previousSigner.KeyID()); !exists {
+			result.keyring.Add(result.previousSigner.Verifier(), "", "")
+		}
+	}
+	// The signer verifies its own records, a rotation narrows the range of its key
+	if result.signer != nil {
+		if _, exists := result.keyring.Entry(result.signer.KeyID()); !exists {
+			result.keyring.Add(result.signer.Verifier(), "", "")
+		}
+	}
+
+	if result.keyring.Len() == 0 && result.signer == nil {
+		return nil, ErrNoKeys
+	}
+
+	if cfg.RevocationList != "" {
+		log.Println("Trying to load the revocation list")
+		list, err := config.LoadRevocationList(cfg.RevocationList)
+		if err != nil {
+			return nil, err
+		}
+		result.revocations = list.Revocations
+	}
+	result.authorities = cfg.RevocationAuthorities
+
+	return result, nil
 }
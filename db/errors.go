@@ -1,14 +1,47 @@
 package db
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var ErrEmptyItem = errors.New("empty DB item pointer found")
 var ErrLastValidatedIsMissing = errors.New("last validated lseq is not present in the database")
-var ErrIncorrectValidationValue = errors.New("incorrect validation value, should be 'hash;signature'")
-var ErrEmptyKey = errors.New("RSA key is empty")
-var ErrNoKeys = errors.New("no RSA keys provided")
+var ErrIncorrectValidationValue = errors.New("incorrect validation value, should be a JSON record or 'hash;signature[;scheme[;algorithm]]'")
+var ErrEmptyKey = errors.New("key is empty")
+var ErrNoKeys = errors.New("no keys provided")
 var ErrAddrNotSpecified = errors.New("server address is not specified")
 var ErrReplicaIDNotSpecified = errors.New("replica ID is not specified")
 var ErrPublicKeyEnvVarNotSpecified = errors.New("public key environment variable is not specified")
 var ErrPrivateKeyEnvVarNotSpecified = errors.New("private key environment variable is not specified")
 var ErrInvalidBatchSize = errors.New("batch size should be positive")
+var ErrNoPublicKey = errors.New("public key is not set, can't verify history")
+var ErrNoPrivateKey = errors.New("private key is not set, can't certify history")
+var ErrInvalidNamespace = errors.New("namespace can only have letters, digits, '-', '_' and '.'")
+var ErrWrongRecordReplica = errors.New("validation record is written to another replica, the server has to own the record replica")
+var ErrAlgorithmMismatch = errors.New("signature algorithm of the record does not match the public key")
+var ErrUnsupportedRecordVersion = errors.New("unsupported validation record version")
+var ErrUnsupportedHashAlgorithm = errors.New("unsupported hash algorithm of the validation record")
+var ErrRecordMismatch = errors.New("validation record belongs to another lseq or replica")
+var ErrIncorrectRotationRecord = errors.New("incorrect key rotation record")
+var ErrIncorrectRevocation = errors.New("incorrect key revocation, needs a key ID and a cut-off lseq or time")
+var ErrSigningKeyRevoked = errors.New("signing key is revoked")
+var ErrRevokedKeyRotation = errors.New("key rotation is signed by a key revoked at the rotation")
+var ErrLastValidatedIsNotSigned = errors.New("last validated lseq has no signed record")
+var ErrIncorrectCABundle = errors.New("CA bundle has no PEM certificates")
+var ErrIncompleteClientCert = errors.New("client certificate needs both a certificate and a key file")
+var ErrOrphanNotVerified = errors.New("validation record after the last validated lseq is not verified")
+
+// Failure of the validation record of a single lseq
+type RecordError struct {
+	Lseq string
+	Err  error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("validation record of lseq %s: %v", e.Lseq, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"log"
+
+	"lsm-verification/models"
+	"lsm-verification/proto"
+
+	"google.golang.org/grpc/status"
+)
+
+// Latest event of the last validated lseq that names a signed record of the
+// signer, with its record. The key is in the reserved prefix but anyone can
+// write it, so a value without a verified record is passed over for the
+// event before it. Nil if there is none.
+func (d *dbApi) readLastValidated(ctx context.Context) (*proto.Value, *models.ValidateItem, error) {
+	latest, err := d.getLastValue(ctx, d.recordKeys.lastValidated)
+	if err != nil || latest == nil {
+		return nil, nil, err
+	}
+	item, err := d.readSignedRecord(ctx, latest.Value)
+	if err == nil || isStatusError(err) {
+		return latest, item, err
+	}
+	log.Printf("Warning: last validated lseq '%s' has no verified record: %v\n", latest.Value, err)
+
+	log.Println("Looking for the last verified update of the last validated lseq")
+	events, err := d.readEventsByKey(ctx, d.recordKeys.lastValidated)
+	if err != nil {
+		return nil, nil, err
+	}
+	for idx := len(events) - 1; idx >= 0; idx-- {
+		if events[idx].Lseq >= latest.Lseq {
+			continue
+		}
+		item, err := d.readSignedRecord(ctx, events[idx].Value)
+		if isStatusError(err) {
+			return nil, nil, err
+		}
+		if err == nil {
+			return &proto.Value{Value: events[idx].Value, Lseq: events[idx].Lseq}, item, nil
+		}
+	}
+	log.Println("No verified update of the last validated lseq")
+	return nil, nil, nil
+}
+
+// Errors of the database rather than of the records
+func isStatusError(err error) bool {
+	_, isStatus := status.FromError(err)
+	return err != nil && isStatus
+}
+
+// The signer keeps the lseq it wrote last, the key can be written by others
+// after it
+func (d *dbApi) GetLastValidated(ctx context.Context) (*models.ValidateItem, error) {
+	if d.lastValidated != "" {
+		return d.readSignedRecord(ctx, d.lastValidated)
+	}
+	_, result, err := d.readLastValidated(ctx)
+	if err != nil || result == nil {
+		return nil, err
+	}
+	log.Println("Constructed the last validated item")
+
+	d.lastValidated = result.LseqItemValid
+	return result, nil
+}
+
+func (d *dbApi) putLastValidated(ctx context.Context, lseq string) error {
+	log.Println("Updating the last validated lseq in the database")
+	if err := d.put(ctx, d.recordKeys.lastValidated, lseq); err != nil {
+		return err
+	}
+	d.lastValidated = lseq
+	return nil
+}
+
+func (d *dbApi) PutLastValidated(ctx context.Context, lseq string) error {
+	return d.putLastValidated(ctx, lseq)
+}
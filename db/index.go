@@ -0,0 +1,199 @@
+package db
+
+import (
+	"context"
+	"log"
+
+	"lsm-verification/proto"
+)
+
+// Records are interleaved with the entries and the service keys,
+// so a page of events is larger than a batch
+const recordPageFactor = 4
+
+// Validation records are written after the entries they cover, so they are
+// read by scanning the record replica events forward from the first
+// requested lseq instead of one GetValue per lseq. A later event of the same key replaces
+// the earlier one, as GetValue would.
+type recordIndex struct {
+	// lseq to the latest record covering it
+	records map[string]*proto.Value
+	// first lseq of the scan, empty before the first request
+	from string
+	// last scanned event, nil before the first page
+	cursor *string
+	// largest lseq with a record, records are written in the lseq order
+	last string
+}
+
+func newRecordIndex() *recordIndex {
+	return &recordIndex{records: make(map[string]*proto.Value)}
+}
+
+func (i *recordIndex) reset(from string) {
+	i.records = make(map[string]*proto.Value)
+	i.from = from
+	i.cursor = nil
+	i.last = ""
+}
+
+// Records of lseqs before the lseq are not requested anymore, a request
+// before it, e.g. a retried batch, starts the scan over
+func (i *recordIndex) prune(lseq string) {
+	for recordLseq := range i.records {
+		if recordLseq < lseq {
+			delete(i.records, recordLseq)
+		}
+	}
+	i.from = lseq
+}
+
+// Lseqs before the last record are unsigned when their record is missing
+func (i *recordIndex) missing(lseqs []string) bool {
+	if i.last >= lseqs[len(lseqs)-1] {
+		return false
+	}
+	for _, lseq := range lseqs {
+		if _, exists := i.records[lseq]; !exists {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *dbApi) readRecordsPage(ctx context.Context) ([]*proto.DBItems_DbItem, error) {
+	limit := d.batchSize * recordPageFactor
+	cursor := d.records.cursor
+	if cursor == nil {
+		seek := &d.records.from
+		if d.recordReplicaId != d.replicaId {
+			var err error
+			if seek, cursor, err = d.attestationStart(ctx, d.records.from); err != nil {
+				return nil, err
+			}
+		}
+		if seek != nil {
+			seekRequest := &proto.SeekGetRequest{
+				Lseq:  *seek,
+				Limit: &limit,
+			}
+			rpcCtx, cancel := d.rpcContext(ctx)
+			defer cancel()
+			items, err := d.client.SeekGet(rpcCtx, seekRequest)
+			if err != nil {
+				return nil, err
+			}
+			return items.Items, nil
+		}
+	}
+
+	eventsRequest := &proto.EventsRequest{
+		ReplicaId: d.recordReplicaId,
+		Lseq:      cursor,
+		Limit:     &limit,
+	}
+	rpcCtx, cancel := d.rpcContext(ctx)
+	defer cancel()
+	items, err := d.client.GetReplicaEvents(rpcCtx, eventsRequest)
+	if err != nil {
+		return nil, err
+	}
+	return items.Items, nil
+}
+
+// Attestations are written in another replica, apart from the entries they
+// cover. The scan seeks to the record of the lseq, or starts after the last
+// update of the last validated lseq before it: the later records are written
+// after that update. Neither means a scan from the start of the replica.
+// Returns the lseq to seek to or the one to start after.
+func (d *dbApi) attestationStart(ctx context.Context, lseq string) (*string, *string, error) {
+	record, err := d.getLastValue(ctx, d.recordKeys.record(lseq))
+	if err != nil {
+		return nil, nil, err
+	}
+	if record != nil {
+		return &record.Lseq, nil, nil
+	}
+
+	log.Println("Looking for the last attestation before lseq", lseq)
+	limit := d.batchSize * recordPageFactor
+	key := d.recordKeys.lastValidated
+	var start, cursor *string
+	for {
+		eventsRequest := &proto.EventsRequest{
+			ReplicaId: d.recordReplicaId,
+			Lseq:      cursor,
+			Key:       &key,
+			Limit:     &limit,
+		}
+		rpcCtx, cancel := d.rpcContext(ctx)
+		events, err := d.client.GetReplicaEvents(rpcCtx, eventsRequest)
+		cancel()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(events.Items) == 0 {
+			return nil, start, nil
+		}
+
+		for _, item := range events.Items {
+			if item == nil {
+				return nil, nil, ErrEmptyItem
+			}
+			if item.Value >= lseq {
+				return nil, start, nil
+			}
+			start = &item.Lseq
+		}
+		cursor = &events.Items[len(events.Items)-1].Lseq
+	}
+}
+
+// Scans until every lseq has a record, the record of the last lseq or of a
+// later one is read, or the replica ends. The records of the lseqs are
+// returned in the same order, nil for an unsigned lseq
+func (d *dbApi) readRecords(ctx context.Context, lseqs []string) ([]*proto.Value, error) {
+	if len(lseqs) == 0 {
+		return []*proto.Value{}, nil
+	}
+	if d.records.from == "" || lseqs[0] < d.records.from {
+		d.records.reset(lseqs[0])
+	}
+	d.records.prune(lseqs[0])
+
+	for d.records.missing(lseqs) {
+		log.Println("Requesting a page of validation records from the database")
+		items, err := d.readRecordsPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			break
+		}
+
+		for _, item := range items {
+			if item == nil {
+				return nil, ErrEmptyItem
+			}
+			lseq, isRecord := d.recordKeys.recordLseq(item.Key)
+			if !isRecord || d.otherReplicaRecord(item.Value) {
+				continue
+			}
+			// A tampered value stays in the index, it fails the verification
+			d.records.records[lseq] = &proto.Value{
+				Value: item.Value,
+				Lseq:  item.Lseq,
+			}
+			if lseq > d.records.last && isRecordOf(lseq, item.Value) {
+				d.records.last = lseq
+			}
+		}
+		d.records.cursor = &items[len(items)-1].Lseq
+	}
+
+	result := make([]*proto.Value, 0, len(lseqs))
+	for _, lseq := range lseqs {
+		result = append(result, d.records.records[lseq])
+	}
+	return result, nil
+}
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"lsm-verification/config"
+	"lsm-verification/fakedb"
+	"lsm-verification/models"
+	"lsm-verification/proto"
+	"lsm-verification/signature"
+)
+
+const testReplica int32 = 1
+
+// Api of the replica of the server with a signing key
+func testDbApi(t *testing.T, srv *fakedb.Server, batchSize uint32) *dbApi {
+	grpcServer, options := fakedb.ServeBufconn(srv)
+	t.Cleanup(grpcServer.Stop)
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDer, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDer, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{}
+	cfg.Env.Db.ServerAddress = fakedb.BufconnAddress
+	cfg.Env.Db.ReplicaID = testReplica
+	cfg.Env.Keys.PublicKey = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer}))
+	cfg.Env.Keys.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDer}))
+	cfg.RunMode = config.RunModeSign
+	cfg.Db.BatchSize = &batchSize
+	dbState, err := CreateDbState(context.Background(), cfg, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(dbState.CloseConnection)
+	return dbState.(*dbApi)
+}
+
+func testPut(t *testing.T, srv *fakedb.Server, key, value string) string {
+	lseq, err := srv.Put(context.Background(), &proto.PutRequest{Key: key, Value: value})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lseq.Lseq
+}
+
+// Record of the lseq with the hash, the signature isn't checked by the index
+func testRecord(t *testing.T, lseq, hash string) string {
+	item := &models.ValidateItem{LseqItemValid: lseq, Hash: hash, Scheme: models.CurrentHashScheme}
+	record := newValidationRecord(item, testReplica, recordKindEntry, signature.AlgorithmEd25519, "key")
+	record.Signature = "sig"
+	encoded, err := record.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func recordHash(t *testing.T, value *proto.Value) string {
+	if value == nil {
+		return ""
+	}
+	record, err := parseValidationRecord(value.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return record.Hash
+}
+
+// Entries with a record after each, every third one unsigned
+func signedEntries(t *testing.T, srv *fakedb.Server, count int) ([]string, map[string]string) {
+	lseqs := []string{}
+	hashes := map[string]string{}
+	for i := 0; i < count; i++ {
+		lseq := testPut(t, srv, "key", "value")
+		lseqs = append(lseqs, lseq)
+		if i%3 == 2 {
+			continue
+		}
+		hashes[lseq] = "hash " + lseq
+		testPut(t, srv, DefaultValidationPrefix+lseq, testRecord(t, lseq, hashes[lseq]))
+	}
+	return lseqs, hashes
+}
+
+func TestReadRecordsJoinsPages(t *testing.T) {
+	srv := fakedb.NewServer(testReplica)
+	d := testDbApi(t, srv, 2)
+	lseqs, hashes := signedEntries(t, srv, 12)
+	// A later record of the key replaces the earlier one
+	hashes[lseqs[9]] = "rewritten"
+	testPut(t, srv, DefaultValidationPrefix+lseqs[9], testRecord(t, lseqs[9], "rewritten"))
+	// Records of other replicas and user data under the prefix are left out
+	other := newValidationRecord(&models.ValidateItem{LseqItemValid: lseqs[11], Hash: "other"}, testReplica+1, recordKindEntry, "", "")
+	otherValue, err := other.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPut(t, srv, DefaultValidationPrefix+lseqs[11], otherValue)
+	testPut(t, srv, DefaultValidationPrefix+"user", "data")
+	more, moreHashes := signedEntries(t, srv, 18)
+	lseqs = append(lseqs, more...)
+	for lseq, hash := range moreHashes {
+		hashes[lseq] = hash
+	}
+
+	for start := 0; start < len(lseqs); start += 6 {
+		batch := lseqs[start : start+6]
+		records, err := d.readRecords(context.Background(), batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != len(batch) {
+			t.Fatalf("got %d records for %d lseqs", len(records), len(batch))
+		}
+		for idx, lseq := range batch {
+			if hash := recordHash(t, records[idx]); hash != hashes[lseq] {
+				t.Fatalf("record of lseq %s has the hash %q, expected %q", lseq, hash, hashes[lseq])
+			}
+		}
+	}
+}
+
+func TestReadRecordsStopsAtTheBatch(t *testing.T) {
+	srv := fakedb.NewServer(testReplica)
+	d := testDbApi(t, srv, 2)
+	lseqs, _ := signedEntries(t, srv, 30)
+
+	// The last lseq is unsigned, the record after it ends the scan
+	if _, err := d.readRecords(context.Background(), lseqs[:3]); err != nil {
+		t.Fatal(err)
+	}
+	if d.records.cursor == nil || *d.records.cursor >= lseqs[10] {
+		t.Fatalf("scan of the first batch went on to %v", d.records.cursor)
+	}
+	// Records before the batch are dropped
+	if _, err := d.readRecords(context.Background(), lseqs[3:6]); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := d.records.records[lseqs[0]]; exists {
+		t.Fatal("record before the batch is kept")
+	}
+
+	// A retried batch starts the scan over
+	records, err := d.readRecords(context.Background(), lseqs[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records[0] == nil {
+		t.Fatal("record of a pruned lseq is not read again")
+	}
+}
+
+func TestReadRecordsOfUnsignedTail(t *testing.T) {
+	srv := fakedb.NewServer(testReplica)
+	d := testDbApi(t, srv, 2)
+	lseqs, _ := signedEntries(t, srv, 6)
+	for i := 0; i < 20; i++ {
+		lseqs = append(lseqs, testPut(t, srv, "tail", "value"))
+	}
+
+	records, err := d.readRecords(context.Background(), lseqs[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records[0] == nil {
+		t.Fatal("record of the signed lseq is missing")
+	}
+	for idx, record := range records[2:] {
+		if record != nil {
+			t.Fatalf("unsigned lseq %s has a record", lseqs[6+idx])
+		}
+	}
+}
@@ -1,11 +1,36 @@
 package db
 
-import "lsm-verification/models"
+import (
+	"context"
+
+	"lsm-verification/models"
+)
 
 type DbState interface {
+	// Checks the record replica and writes the key rotation and revocations
+	// of the signer, only the Sign and Attest modes call it
+	StartSigning(ctx context.Context) error
 	CloseConnection()
-	ReadBatch(startLseq *string) ([]models.DbItem, error)
-	ReadBatchValidated(lseqs []string) ([]models.ValidateItem, error)
-	GetLastValidated() (*models.ValidateItem, error)
-	PutBatch(items []models.ValidateItem) error
+	ReadBatch(ctx context.Context, startLseq *string) ([]models.DbItem, error)
+	ReadBatchValidated(ctx context.Context, lseqs []string) ([]models.ValidateItem, error)
+	GetLastValidated(ctx context.Context) (*models.ValidateItem, error)
+	PutBatch(ctx context.Context, items []models.ValidateItem) error
+	// Signs the last item, the others are stored as unsigned hashes if storeHashes is set
+	PutCheckpoint(ctx context.Context, items []models.ValidateItem, storeHashes bool) error
+	// Verified records written after the last validated lseq, ordered by lseq
+	ReadOrphans(ctx context.Context) ([]models.ValidateItem, error)
+	PutLastValidated(ctx context.Context, lseq string) error
+	// Verified signed record at or before the lseq, nil if there is none
+	ReadAnchor(ctx context.Context, lseq string) (*models.ValidateItem, error)
+	// Verified signed record of the lseq, nil if it is missing
+	ReadSignedRecord(ctx context.Context, lseq string) (*models.ValidateItem, error)
+	HasEntry(ctx context.Context, lseq string) (bool, error)
+	// Entry of the lseq, nil if it is missing
+	ReadEntry(ctx context.Context, lseq string) (*models.DbItem, error)
+	// First entry after the lseq, nil from the genesis, the number of entries
+	// and the number of all events after it
+	ReadTail(ctx context.Context, lseq *string) (string, int64, int64, error)
+	// Entries after from, from the genesis if it is nil, up to the lseq to
+	CountEntries(ctx context.Context, from *string, to string) (int64, error)
+	ReadHead(ctx context.Context) (string, error)
 }
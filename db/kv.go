@@ -1,28 +1,258 @@
 package db
 
-import "strings"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 
-const validationPrefix = "_v_"
+	"lsm-verification/models"
+	"lsm-verification/signature"
+)
 
-const lastValidated = "_v_asd"
+// Reserved key prefix of the validation records by default
+const DefaultValidationPrefix = "_v_"
 
-func isValidationKey(key string) bool {
-	return strings.HasPrefix(key, validationPrefix)
+// Service keys within the namespace of a signer
+const (
+	lastValidatedName = "asd"
+	rotationName      = "rotation"
+	revocationName    = "revocation"
+)
+
+// Validation keys of one signer: '<prefix>[<namespace>/]<lseq>' records and
+// the service keys next to them. The namespace keeps the keys of signers
+// sharing a replica apart. A key in the prefix is a validation key only if
+// its value is one of its kind.
+type recordKeys struct {
+	prefix string
+	// namespaces of the signers of the replica, the default one included
+	signers map[string]bool
+	// prefix of the record and service keys of the signer
+	records       string
+	lastValidated string
+	rotation      string
+	revocation    string
 }
 
-func createValidationKey(key string) string {
-	return validationPrefix + key
+// The records of an attested replica are stored next to the ones of the
+// replica that stores them, the lseq keeps their keys apart and the service
+// keys name the attested replica. The keys of the signers in
+// signerNamespaces are validation keys as well.
+func newRecordKeys(prefix, namespace string, signerNamespaces []string, replicaId, recordReplicaId int32) (recordKeys, error) {
+	if prefix == "" {
+		prefix = DefaultValidationPrefix
+	}
+	signers := map[string]bool{"": true}
+	for _, signer := range append([]string{namespace}, signerNamespaces...) {
+		if signer != "" && !isNamespace(signer) {
+			return recordKeys{}, ErrInvalidNamespace
+		}
+		signers[signer] = true
+	}
+	records := prefix
+	if namespace != "" {
+		records = prefix + namespace + "/"
+	}
+	suffix := ""
+	if replicaId != recordReplicaId {
+		suffix = fmt.Sprintf("_%d", replicaId)
+	}
+	return recordKeys{
+		prefix:        prefix,
+		signers:       signers,
+		records:       records,
+		lastValidated: records + lastValidatedName + suffix,
+		rotation:      records + rotationName + suffix,
+		revocation:    records + revocationName + suffix,
+	}, nil
 }
 
-func splitHashAndSignature(joined string) (string, string, error) {
-	split := strings.Split(joined, ";")
-	if len(split) != 2 {
-		return "", "", ErrIncorrectValidationValue
+func (k recordKeys) record(lseq string) string {
+	return k.records + lseq
+}
+
+// Lseq named by a record key of the signer, false for other keys. Lseqs are
+// opaque, the value of the record tells whether it is one.
+func (k recordKeys) recordLseq(key string) (string, bool) {
+	if !strings.HasPrefix(key, k.records) {
+		return "", false
+	}
+	lseq := strings.TrimPrefix(key, k.records)
+	if lseq == "" || strings.IndexByte(lseq, '/') >= 0 || serviceName(lseq) != "" {
+		return "", false
+	}
+	return lseq, true
+}
+
+// Namespace and name of a key of a signer of the replica, false for other
+// keys. The name is told apart by the value only.
+func (k recordKeys) signerKey(key string) (string, string, bool) {
+	if !strings.HasPrefix(key, k.prefix) {
+		return "", "", false
+	}
+	name := strings.TrimPrefix(key, k.prefix)
+	namespace := ""
+	if idx := strings.IndexByte(name, '/'); idx >= 0 {
+		namespace, name = name[:idx], name[idx+1:]
+	}
+	if !k.signers[namespace] || name == "" {
+		return "", "", false
 	}
+	return namespace, name, true
+}
+
+func (k recordKeys) namespaceRecord(namespace, lseq string) string {
+	if namespace == "" {
+		return k.prefix + lseq
+	}
+	return k.prefix + namespace + "/" + lseq
+}
+
+// The service key the name is, without the attested replica, empty for a
+// record name
+func serviceName(name string) string {
+	if idx := strings.LastIndexByte(name, '_'); idx >= 0 && isDigits(name[idx+1:]) {
+		name = name[:idx]
+	}
+	switch name {
+	case lastValidatedName, rotationName, revocationName:
+		return name
+	}
+	return ""
+}
+
+// The rule of the hash schemes before models.HashSchemeSignerKeys: a record
+// or service key of any namespace in the prefix with a value of its kind.
+func (k recordKeys) isLegacyValidationItem(key, value string) bool {
+	if !strings.HasPrefix(key, k.prefix) {
+		return false
+	}
+	name := strings.TrimPrefix(key, k.prefix)
+	if idx := strings.IndexByte(name, '/'); idx >= 0 {
+		if !isNamespace(name[:idx]) {
+			return false
+		}
+		name = name[idx+1:]
+	}
+
+	switch serviceName(name) {
+	case lastValidatedName:
+		// The schemes before skipped every key in the prefix
+		return true
+	case rotationName, revocationName:
+		return strings.HasPrefix(value, "{") && json.Valid([]byte(value))
+	}
+	record, err := parseValidationRecord(value)
+	return err == nil && (record.Version == recordVersionLegacy || record.Lseq == name)
+}
 
-	return split[0], split[1], nil
+// Record of the signer keyed by its own lseq
+func isRecordOf(lseq, value string) bool {
+	record, err := parseValidationRecord(value)
+	return err == nil && record.Version != recordVersionLegacy && record.Lseq == lseq
 }
 
-func joinHashAndSignature(hash, signature string) string {
-	return hash + ";" + signature
+func isRotationRecord(value string) bool {
+	record := &rotationRecord{}
+	if err := json.Unmarshal([]byte(value), record); err != nil {
+		return false
+	}
+	return record.Version == rotationRecordVersion && record.OldKeyID != "" && record.NewKeyID != "" &&
+		record.OldSignature != "" && record.NewSignature != ""
+}
+
+func isRevocationRecord(value string) bool {
+	record := &revocationRecord{}
+	if err := json.Unmarshal([]byte(value), record); err != nil {
+		return false
+	}
+	return record.Version == revocationRecordVersion && record.KeyID != "" && record.SignerKeyID != "" &&
+		record.Signature != ""
+}
+
+// Record or service event of a signer of the replica, told apart by the key
+// and verified by the value: a record names its own lseq, the last validated
+// lseq names a record of the same signer, rotations and revocations parse.
+// Anything else in the prefix is hashed as an entry, so user data can't hide
+// behind a reserved key.
+func (d *dbApi) isValidationItem(ctx context.Context, key, value string) (bool, error) {
+	namespace, name, ok := d.recordKeys.signerKey(key)
+	if !ok {
+		return false, nil
+	}
+	switch serviceName(name) {
+	case rotationName:
+		return isRotationRecord(value), nil
+	case revocationName:
+		return isRevocationRecord(value), nil
+	case lastValidatedName:
+		if value == "" {
+			return false, nil
+		}
+		record, err := d.getReplicaValue(ctx, d.recordKeys.namespaceRecord(namespace, value), d.replicaId)
+		if err != nil || record == nil {
+			return false, err
+		}
+		return isRecordOf(value, record.Value), nil
+	}
+	return isRecordOf(name, value), nil
+}
+
+// Records of attestations of other replicas stored in the replica. The
+// replica of a record is in its value, lseqs are opaque.
+func (d *dbApi) otherReplicaRecord(value string) bool {
+	record, err := parseValidationRecord(value)
+	return err == nil && record.Version != recordVersionLegacy && record.ReplicaID != d.replicaId
+}
+
+func isNamespace(namespace string) bool {
+	if namespace == "" {
+		return false
+	}
+	for _, c := range namespace {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Values written before hash schemes were introduced have no scheme
+// field and are hashed with models.HashSchemeConcat, values written
+// before pluggable algorithms are signed with RSA-PSS.
+func splitHashAndSignature(joined string) (string, string, models.HashScheme, string, error) {
+	split := strings.Split(joined, ";")
+	if len(split) < 2 || len(split) > 4 {
+		return "", "", 0, "", ErrIncorrectValidationValue
+	}
+
+	scheme := models.HashSchemeConcat
+	if len(split) > 2 {
+		parsed, err := strconv.ParseUint(split[2], 10, 32)
+		if err != nil {
+			return "", "", 0, "", ErrIncorrectValidationValue
+		}
+		scheme = models.HashScheme(parsed)
+	}
+
+	algorithm := signature.AlgorithmRSAPSS
+	if len(split) > 3 {
+		algorithm = split[3]
+	}
+
+	return split[0], split[1], scheme, algorithm, nil
 }
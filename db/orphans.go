@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"log"
+	"sort"
+
+	"lsm-verification/models"
+	"lsm-verification/proto"
+)
+
+// Pages without a record of the signer that end the orphan scan, the records
+// of a batch are written one after another
+const orphanScanGap = 4
+
+// A signer interrupted while writing a batch leaves records after the last
+// update of the last validated lseq. They are found by scanning the replica
+// events from that update, the latest event of a key wins. Only the values
+// that are records of the lseq in their key count. The scan stops at the end
+// of the replica or after orphanScanGap pages without a record.
+func (d *dbApi) ReadOrphans(ctx context.Context) ([]models.ValidateItem, error) {
+	lastValidatedValue, _, err := d.readLastValidated(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var cursor *string
+	lastLseq := ""
+	if lastValidatedValue != nil {
+		cursor = &lastValidatedValue.Lseq
+		lastLseq = lastValidatedValue.Value
+	}
+
+	log.Println("Looking for validation records after the last validated lseq")
+	limit := d.batchSize * recordPageFactor
+	records := make(map[string]*proto.Value)
+	for gap := 0; gap < orphanScanGap; {
+		eventsRequest := &proto.EventsRequest{
+			ReplicaId: d.recordReplicaId,
+			Lseq:      cursor,
+			Limit:     &limit,
+		}
+		rpcCtx, cancel := d.rpcContext(ctx)
+		events, err := d.client.GetReplicaEvents(rpcCtx, eventsRequest)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if len(events.Items) == 0 {
+			break
+		}
+
+		found := false
+		for _, item := range events.Items {
+			if item == nil {
+				return nil, ErrEmptyItem
+			}
+			lseq, isRecord := d.recordKeys.recordLseq(item.Key)
+			if !isRecord || lseq <= lastLseq || !isRecordOf(lseq, item.Value) || d.otherReplicaRecord(item.Value) {
+				continue
+			}
+			found = true
+			records[lseq] = &proto.Value{
+				Value: item.Value,
+				Lseq:  item.Lseq,
+			}
+		}
+		cursor = &events.Items[len(events.Items)-1].Lseq
+		if found {
+			gap = 0
+		} else {
+			gap++
+		}
+	}
+
+	lseqs := make([]string, 0, len(records))
+	for lseq := range records {
+		lseqs = append(lseqs, lseq)
+	}
+	sort.Strings(lseqs)
+
+	result := make([]models.ValidateItem, 0, len(lseqs))
+	for _, lseq := range lseqs {
+		record, err := parseValidationRecord(records[lseq].Value)
+		if err != nil {
+			log.Println("Orphaned validation record is malformed:", lseq)
+			return nil, err
+		}
+		item, err := d.verifiedItem(record, lseq, records[lseq])
+		if err != nil {
+			log.Println("Orphaned validation record is not verified:", lseq)
+			return nil, ErrOrphanNotVerified
+		}
+		result = append(result, *item)
+	}
+	log.Printf("Found %d orphaned validation records\n", len(result))
+
+	return result, nil
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"lsm-verification/fakedb"
+	"lsm-verification/models"
+)
+
+// Signed record of the entry written without updating the last validated lseq
+func putOrphan(t *testing.T, srv *fakedb.Server, d *dbApi, lseq string) {
+	encoded, err := d.signRecord(&models.ValidateItem{LseqItemValid: lseq, Hash: "hash " + lseq, Scheme: models.CurrentHashScheme}, recordKindEntry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPut(t, srv, DefaultValidationPrefix+lseq, encoded)
+}
+
+func orphanLseqs(t *testing.T, d *dbApi) []string {
+	orphans, err := d.ReadOrphans(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lseqs := []string{}
+	for _, orphan := range orphans {
+		lseqs = append(lseqs, orphan.LseqItemValid)
+	}
+	return lseqs
+}
+
+func TestReadOrphans(t *testing.T) {
+	srv := fakedb.NewServer(testReplica)
+	d := testDbApi(t, srv, 2)
+	signed := []models.ValidateItem{}
+	for i := 0; i < 4; i++ {
+		lseq := testPut(t, srv, "key", "value")
+		signed = append(signed, models.ValidateItem{LseqItemValid: lseq, Hash: "hash " + lseq, Scheme: models.CurrentHashScheme})
+	}
+	if err := d.PutBatch(context.Background(), signed); err != nil {
+		t.Fatal(err)
+	}
+	if lseqs := orphanLseqs(t, d); len(lseqs) != 0 {
+		t.Fatalf("orphans %v after a complete batch", lseqs)
+	}
+
+	entries := []string{}
+	for i := 0; i < 4; i++ {
+		entries = append(entries, testPut(t, srv, "key", "value"))
+	}
+	// Written out of order, the records are returned in the lseq order
+	putOrphan(t, srv, d, entries[1])
+	putOrphan(t, srv, d, entries[0])
+	// Neither user data nor a record of another lseq is an orphan
+	testPut(t, srv, DefaultValidationPrefix+entries[2], "data")
+	testPut(t, srv, DefaultValidationPrefix+entries[3], testRecord(t, entries[2], "hash"))
+	lseqs := orphanLseqs(t, d)
+	if len(lseqs) != 2 || lseqs[0] != entries[0] || lseqs[1] != entries[1] {
+		t.Fatalf("orphans are %v, expected %v", lseqs, entries[:2])
+	}
+}
+
+// The first page of 8 events holds a record, the scan gives up after the 4
+// pages following it without one
+func TestReadOrphansStopsAfterGap(t *testing.T) {
+	for _, test := range []struct {
+		filler int
+		found  int
+	}{
+		// the second record is the last event of the fifth page
+		{filler: 36, found: 2},
+		{filler: 37, found: 1},
+	} {
+		srv := fakedb.NewServer(testReplica)
+		d := testDbApi(t, srv, 2)
+		putOrphan(t, srv, d, testPut(t, srv, "key", "value"))
+		for i := 0; i < test.filler; i++ {
+			testPut(t, srv, "key", "value")
+		}
+		putOrphan(t, srv, d, testPut(t, srv, "key", "value"))
+		if lseqs := orphanLseqs(t, d); len(lseqs) != test.found {
+			t.Fatalf("%d orphans after %d entries without records, expected %d", len(lseqs), test.filler, test.found)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// Fixed number of goroutines fed through a bounded queue. Tasks store
+// their results by index, so the results keep the order of the input.
+type workerPool struct {
+	workers int
+	queue   int
+}
+
+// Zero workers means one per CPU, zero queue means two items per worker
+func newWorkerPool(workers, queue int) *workerPool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if queue <= 0 {
+		queue = 2 * workers
+	}
+	return &workerPool{
+		workers: workers,
+		queue:   queue,
+	}
+}
+
+// Runs the task for indexes [0, count), no task is queued after ctx is done.
+// Returns the number of leading tasks that succeeded and the error of the
+// first one that didn't.
+func (p *workerPool) run(ctx context.Context, count int, task func(idx int) error) (int, error) {
+	errs := make([]error, count)
+	queue := make(chan int, p.queue)
+	var wg sync.WaitGroup
+
+	workers := p.workers
+	if workers > count {
+		workers = count
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range queue {
+				errs[idx] = task(idx)
+			}
+		}()
+	}
+
+	queued := 0
+	for ; queued < count && ctx.Err() == nil; queued++ {
+		queue <- queued
+	}
+	close(queue)
+	wg.Wait()
+
+	for idx := 0; idx < queued; idx++ {
+		if errs[idx] != nil {
+			return idx, errs[idx]
+		}
+	}
+	if queued < count {
+		return queued, ctx.Err()
+	}
+	return count, nil
+}
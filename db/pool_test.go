@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolKeepsOrder(t *testing.T) {
+	results := make([]int, 100)
+	// Later tasks finish first
+	done, err := newWorkerPool(4, 0).run(context.Background(), len(results), func(idx int) error {
+		time.Sleep(time.Duration(len(results)-idx) * 10 * time.Microsecond)
+		results[idx] = idx * idx
+		return nil
+	})
+	if err != nil || done != len(results) {
+		t.Fatalf("%d tasks done, error %v", done, err)
+	}
+	for idx, result := range results {
+		if result != idx*idx {
+			t.Fatalf("result %d is %d", idx, result)
+		}
+	}
+}
+
+func TestPoolBoundsWorkers(t *testing.T) {
+	var running, most int32
+	_, err := newWorkerPool(3, 1).run(context.Background(), 30, func(idx int) error {
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			seen := atomic.LoadInt32(&most)
+			if current <= seen || atomic.CompareAndSwapInt32(&most, seen, current) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if most > 3 {
+		t.Fatalf("%d tasks ran at once on 3 workers", most)
+	}
+}
+
+func TestPoolReportsFirstFailure(t *testing.T) {
+	failure := errors.New("failure")
+	done, err := newWorkerPool(4, 0).run(context.Background(), 20, func(idx int) error {
+		// The later failure finishes first
+		if idx == 12 {
+			return errors.New("later failure")
+		}
+		if idx == 7 {
+			time.Sleep(time.Millisecond)
+			return failure
+		}
+		return nil
+	})
+	if done != 7 || err != failure {
+		t.Fatalf("%d leading tasks done with %v, expected 7 with %v", done, err, failure)
+	}
+}
+
+func TestPoolStopsQueueing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var ran int32
+	done, err := newWorkerPool(1, 1).run(ctx, 100, func(idx int) error {
+		atomic.AddInt32(&ran, 1)
+		if idx == 5 {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("error is %v, expected %v", err, context.Canceled)
+	}
+	if done != int(ran) || done >= 100 {
+		t.Fatalf("%d leading tasks done of %d run", done, ran)
+	}
+}
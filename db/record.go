@@ -0,0 +1,129 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"lsm-verification/models"
+)
+
+const (
+	// 'hash;signature[;scheme[;algorithm]]', the signature covers the hash only
+	recordVersionLegacy = 0
+	// canonical JSON, the signature covers every other field of the record but
+	// the MMR peaks
+	recordVersionJSON = 1
+)
+
+const hashAlgorithmSHA256 = "sha256"
+
+const (
+	// signed record of a single entry
+	recordKindEntry = ""
+	// unsigned running hash between two checkpoints
+	recordKindHash = "hash"
+	// signed record that covers every entry since the previous one
+	recordKindCheckpoint = "checkpoint"
+)
+
+// Stored as the value of '_v_<lseq>'. The fields are marshalled in the
+// declaration order without whitespace, which makes the encoding canonical.
+type validationRecord struct {
+	Version            int               `json:"version"`
+	Kind               string            `json:"kind,omitempty"`
+	Lseq               string            `json:"lseq"`
+	ReplicaID          int32             `json:"replica_id"`
+	Scheme             models.HashScheme `json:"scheme"`
+	HashAlgorithm      string            `json:"hash_algorithm"`
+	Hash               string            `json:"hash"`
+	SignatureAlgorithm string            `json:"signature_algorithm,omitempty"`
+	KeyID              string            `json:"key_id,omitempty"`
+	SignedAt           string            `json:"signed_at,omitempty"`
+	Signature          string            `json:"signature,omitempty"`
+	// Unsigned, checked against the hash by the signer resuming from it
+	MMR *models.MMRState `json:"mmr,omitempty"`
+}
+
+func newValidationRecord(item *models.ValidateItem, replicaId int32, kind, algorithm, keyId string) *validationRecord {
+	record := newHashRecord(item, replicaId)
+	record.Kind = kind
+	record.MMR = item.MMR
+	record.SignatureAlgorithm = algorithm
+	record.KeyID = keyId
+	record.SignedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	return record
+}
+
+func newHashRecord(item *models.ValidateItem, replicaId int32) *validationRecord {
+	return &validationRecord{
+		Version:       recordVersionJSON,
+		Kind:          recordKindHash,
+		Lseq:          item.LseqItemValid,
+		ReplicaID:     replicaId,
+		Scheme:        item.Scheme,
+		HashAlgorithm: hashAlgorithmSHA256,
+		Hash:          item.Hash,
+	}
+}
+
+func parseValidationRecord(value string) (*validationRecord, error) {
+	if !strings.HasPrefix(value, "{") {
+		hash, signed, scheme, algorithm, err := splitHashAndSignature(value)
+		if err != nil {
+			return nil, err
+		}
+		return &validationRecord{
+			Version:            recordVersionLegacy,
+			Scheme:             scheme,
+			HashAlgorithm:      hashAlgorithmSHA256,
+			Hash:               hash,
+			SignatureAlgorithm: algorithm,
+			Signature:          signed,
+		}, nil
+	}
+
+	record := &validationRecord{}
+	if err := json.Unmarshal([]byte(value), record); err != nil {
+		return nil, ErrIncorrectValidationValue
+	}
+	if record.Version != recordVersionJSON {
+		return nil, ErrUnsupportedRecordVersion
+	}
+	if record.HashAlgorithm != hashAlgorithmSHA256 {
+		return nil, ErrUnsupportedHashAlgorithm
+	}
+	if record.Kind != recordKindEntry && record.Kind != recordKindHash && record.Kind != recordKindCheckpoint {
+		return nil, ErrIncorrectValidationValue
+	}
+
+	return record, nil
+}
+
+func (r *validationRecord) encode() (string, error) {
+	encoded, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// Hex-encoded digest that the signature of the record covers
+func (r *validationRecord) signedDigest() (string, error) {
+	if r.Version == recordVersionLegacy {
+		return r.Hash, nil
+	}
+
+	unsigned := *r
+	unsigned.Signature = ""
+	unsigned.MMR = nil
+	encoded, err := unsigned.encode()
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256([]byte(encoded))
+	return hex.EncodeToString(digest[:]), nil
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"testing"
+
+	"lsm-verification/models"
+	"lsm-verification/signature"
+)
+
+func TestParseLegacyRecord(t *testing.T) {
+	tests := []struct {
+		value     string
+		scheme    models.HashScheme
+		algorithm string
+		err       error
+	}{
+		{value: "hash;sig", scheme: models.HashSchemeConcat, algorithm: signature.AlgorithmRSAPSS},
+		{value: "hash;sig;2", scheme: models.HashSchemeLengthPrefixed, algorithm: signature.AlgorithmRSAPSS},
+		{value: "hash;sig;2;ed25519", scheme: models.HashSchemeLengthPrefixed, algorithm: signature.AlgorithmEd25519},
+		{value: "hash", err: ErrIncorrectValidationValue},
+		{value: "hash;sig;x", err: ErrIncorrectValidationValue},
+		{value: "a;b;1;c;d", err: ErrIncorrectValidationValue},
+	}
+	for _, test := range tests {
+		record, err := parseValidationRecord(test.value)
+		if err != test.err {
+			t.Fatalf("%q: error is %v, expected %v", test.value, err, test.err)
+		}
+		if err != nil {
+			continue
+		}
+		if record.Version != recordVersionLegacy || record.Hash != "hash" || record.Signature != "sig" ||
+			record.Scheme != test.scheme || record.SignatureAlgorithm != test.algorithm {
+			t.Fatalf("%q: parsed as %+v", test.value, record)
+		}
+		// The legacy signature covers the hash alone
+		if digest, _ := record.signedDigest(); digest != "hash" {
+			t.Fatalf("%q: digest is %s", test.value, digest)
+		}
+	}
+}
+
+func TestParseRecord(t *testing.T) {
+	item := &models.ValidateItem{LseqItemValid: "lseq", Hash: "hash", Scheme: models.CurrentHashScheme}
+	record := newValidationRecord(item, 3, recordKindCheckpoint, signature.AlgorithmEd25519, "key")
+	record.Signature = "sig"
+	encoded, err := record.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseValidationRecord(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *parsed != *record {
+		t.Fatalf("parsed %+v, encoded %+v", parsed, record)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		err   error
+	}{
+		{name: "unknown version", value: `{"version":2,"lseq":"lseq","hash_algorithm":"sha256"}`, err: ErrUnsupportedRecordVersion},
+		{name: "unknown hash algorithm", value: `{"version":1,"lseq":"lseq","hash_algorithm":"md5"}`, err: ErrUnsupportedHashAlgorithm},
+		{name: "unknown kind", value: `{"version":1,"kind":"other","lseq":"lseq","hash_algorithm":"sha256"}`, err: ErrIncorrectValidationValue},
+		{name: "not a record", value: `{"version":"1"}`, err: ErrIncorrectValidationValue},
+	}
+	for _, test := range tests {
+		if _, err := parseValidationRecord(test.value); err != test.err {
+			t.Fatalf("%s: error is %v, expected %v", test.name, err, test.err)
+		}
+	}
+}
+
+// The signature covers every other field of the record but the MMR peaks
+func TestRecordDigest(t *testing.T) {
+	item := &models.ValidateItem{LseqItemValid: "lseq", Hash: "hash", Scheme: models.CurrentHashScheme}
+	record := newValidationRecord(item, 3, recordKindEntry, signature.AlgorithmEd25519, "key")
+	digest, err := record.signedDigest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := *record
+	signed.Signature = "sig"
+	if signedDigest, _ := signed.signedDigest(); signedDigest != digest {
+		t.Fatal("digest depends on the signature")
+	}
+	withPeaks := *record
+	withPeaks.MMR = &models.MMRState{Size: 1, Peaks: []string{"peak"}}
+	if peaksDigest, _ := withPeaks.signedDigest(); peaksDigest != digest {
+		t.Fatal("digest depends on the MMR peaks")
+	}
+
+	edits := map[string]func(r *validationRecord){
+		"lseq":      func(r *validationRecord) { r.Lseq = "other" },
+		"replica":   func(r *validationRecord) { r.ReplicaID = 4 },
+		"scheme":    func(r *validationRecord) { r.Scheme = models.HashSchemeConcat },
+		"hash":      func(r *validationRecord) { r.Hash = "other" },
+		"algorithm": func(r *validationRecord) { r.SignatureAlgorithm = signature.AlgorithmRSAPSS },
+		"key":       func(r *validationRecord) { r.KeyID = "other" },
+		"kind":      func(r *validationRecord) { r.Kind = recordKindCheckpoint },
+		"time":      func(r *validationRecord) { r.SignedAt = "other" },
+	}
+	for name, edit := range edits {
+		edited := *record
+		edit(&edited)
+		if editedDigest, _ := edited.signedDigest(); editedDigest == digest {
+			t.Fatalf("digest doesn't cover the %s", name)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+
+	"lsm-verification/config"
+	"lsm-verification/signature"
+)
+
+const revocationRecordVersion = 1
+
+// Published revocation, signed by the revoked key, a key of the keyring that
+// is not revoked at the revocation or a revocation authority
+type revocationRecord struct {
+	Version     int    `json:"version"`
+	ReplicaID   int32  `json:"replica_id"`
+	KeyID       string `json:"key_id"`
+	RevokedFrom string `json:"revoked_from,omitempty"`
+	RevokedAt   string `json:"revoked_at,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	SignerKeyID string `json:"signer_key_id"`
+	SignedAt    string `json:"signed_at"`
+	Signature   string `json:"signature,omitempty"`
+	// lseq of the revocation event
+	eventLseq string
+}
+
+func (r *revocationRecord) signedDigest() (string, error) {
+	unsigned := *r
+	unsigned.Signature = ""
+	encoded, err := json.Marshal(unsigned)
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256(encoded)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+func (r *revocationRecord) matches(revocation *config.Revocation) bool {
+	return r.KeyID == revocation.KeyID &&
+		r.RevokedFrom == revocation.RevokedFrom &&
+		r.RevokedAt == revocation.RevokedAt
+}
+
+func (d *dbApi) revoke(keyID, revokedFrom, revokedAt string) error {
+	var at time.Time
+	if revokedAt != "" {
+		parsed, err := time.Parse(time.RFC3339, revokedAt)
+		if err != nil {
+			return err
+		}
+		at = parsed
+	}
+	if revokedFrom == "" && at.IsZero() {
+		return ErrIncorrectRevocation
+	}
+
+	log.Printf("Key %s is revoked from lseq '%s' and time '%s'\n", keyID, revokedFrom, revokedAt)
+	return d.keyring.Revoke(keyID, revokedFrom, at)
+}
+
+func (d *dbApi) readRevocations(ctx context.Context) ([]*revocationRecord, error) {
+	items, err := d.readEventsByKey(ctx, d.recordKeys.revocation)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*revocationRecord, 0, len(items))
+	for _, item := range items {
+		// Other values of the key are hashed as entries
+		if !isRevocationRecord(item.Value) {
+			log.Println("Warning: skipping a value of the revocation key that is not a revocation", item.Lseq)
+			continue
+		}
+		record := &revocationRecord{}
+		if err := json.Unmarshal([]byte(item.Value), record); err != nil {
+			return nil, ErrIncorrectRevocation
+		}
+		if record.ReplicaID != d.replicaId {
+			return nil, ErrIncorrectRevocation
+		}
+		record.eventLseq = item.Lseq
+		result = append(result, record)
+	}
+	return result, nil
+}
+
+func (d *dbApi) isAuthority(keyID string) bool {
+	for _, authority := range d.authorities {
+		if authority == keyID {
+			return true
+		}
+	}
+	return false
+}
+
+// A key can always revoke itself and an authority any key, their revocations
+// are applied before the others
+func (d *dbApi) trustedRevocation(record *revocationRecord) bool {
+	return record.SignerKeyID == record.KeyID || d.isAuthority(record.SignerKeyID)
+}
+
+// The signer is placed at the lseq of the revocation event like the old key
+// of a rotation, a revoked signer that can't be placed is not trusted
+func (d *dbApi) revokedSigner(signerEntry *signature.KeyringEntry, record *revocationRecord) bool {
+	if signerEntry.RevokedFrom == "" && signerEntry.RevokedAt.IsZero() {
+		return false
+	}
+	if record.eventLseq == "" || d.recordReplicaId != d.replicaId {
+		return true
+	}
+	var signedAt *time.Time
+	if parsed, err := time.Parse(time.RFC3339Nano, record.SignedAt); err == nil {
+		signedAt = &parsed
+	}
+	return signerEntry.Revoked(record.eventLseq, signedAt)
+}
+
+// Revocations of unknown keys don't matter, a revocation signed by
+// an unknown or revoked key or with a bad signature is ignored. Returns whether
+// the record is settled, a rotation can still introduce the unknown keys.
+func (d *dbApi) applyRevocationRecord(record *revocationRecord) (bool, error) {
+	if _, exists := d.keyring.Entry(record.KeyID); !exists {
+		return false, nil
+	}
+	signerEntry, exists := d.keyring.Entry(record.SignerKeyID)
+	if !exists {
+		return false, nil
+	}
+	if !d.trustedRevocation(record) && d.revokedSigner(signerEntry, record) {
+		log.Printf("Warning: skipping the revocation of %s by the revoked key %s\n", record.KeyID, record.SignerKeyID)
+		return true, nil
+	}
+
+	digest, err := record.signedDigest()
+	if err != nil {
+		return false, err
+	}
+	if err := signerEntry.Verifier.Verify(record.Signature, digest); err != nil {
+		log.Println("Warning: skipping the revocation with an invalid signature", err)
+		return true, nil
+	}
+	if record.RevokedFrom == "" {
+		log.Println("Warning: revocation has only a cut-off time set by the signer", record.KeyID)
+	}
+	return true, d.revoke(record.KeyID, record.RevokedFrom, record.RevokedAt)
+}
+
+// Revocations waiting for the keys they name
+type pendingRevocations struct {
+	published []*revocationRecord
+	local     []config.Revocation
+}
+
+// Applies the revocations of the keys in the keyring, the others are kept
+// until a rotation introduces their keys. The local and the trusted
+// revocations go first, so a leaked key can't revoke the key that revokes it.
+func (d *dbApi) applyRevocations(pending *pendingRevocations) error {
+	local := []config.Revocation{}
+	for _, revocation := range pending.local {
+		if _, exists := d.keyring.Entry(revocation.KeyID); !exists {
+			local = append(local, revocation)
+			continue
+		}
+		if err := d.revoke(revocation.KeyID, revocation.RevokedFrom, revocation.RevokedAt); err != nil {
+			return err
+		}
+	}
+	published := []*revocationRecord{}
+	for _, trusted := range []bool{true, false} {
+		for _, record := range pending.published {
+			if d.trustedRevocation(record) != trusted {
+				continue
+			}
+			settled, err := d.applyRevocationRecord(record)
+			if err != nil {
+				return err
+			}
+			if !settled {
+				published = append(published, record)
+			}
+		}
+	}
+	pending.published, pending.local = published, local
+	return nil
+}
+
+func (d *dbApi) publishRevocation(ctx context.Context, revocation *config.Revocation) error {
+	record := &revocationRecord{
+		Version:     revocationRecordVersion,
+		ReplicaID:   d.replicaId,
+		KeyID:       revocation.KeyID,
+		RevokedFrom: revocation.RevokedFrom,
+		RevokedAt:   revocation.RevokedAt,
+		Reason:      revocation.Reason,
+		SignerKeyID: d.signer.KeyID(),
+		SignedAt:    time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	digest, err := record.signedDigest()
+	if err != nil {
+		return err
+	}
+	if record.Signature, err = d.signer.Sign(digest); err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	log.Println("Publishing the revocation of the key", revocation.KeyID)
+	return d.put(ctx, d.recordKeys.revocation, string(encoded))
+}
+
+// Reads the published revocations and applies them with the local list
+// before the rotations, so a revoked key can't rotate past its cut-off
+func (d *dbApi) loadRevocations(ctx context.Context, local []config.Revocation) ([]*revocationRecord, *pendingRevocations, error) {
+	log.Println("Loading the key revocations")
+	published, err := d.readRevocations(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	pending := &pendingRevocations{published: published, local: local}
+	if err := d.applyRevocations(pending); err != nil {
+		return nil, nil, err
+	}
+	return published, pending, nil
+}
+
+// The signer publishes the local revocations missing from the database and
+// refuses to sign with a revoked key
+func (d *dbApi) publishRevocations(ctx context.Context, published []*revocationRecord, local []config.Revocation) error {
+	for idx := range local {
+		revocation := &local[idx]
+		if revocation.KeyID == d.signer.KeyID() {
+			continue
+		}
+
+		isPublished := false
+		for _, record := range published {
+			isPublished = isPublished || record.matches(revocation)
+		}
+		if !isPublished {
+			if err := d.publishRevocation(ctx, revocation); err != nil {
+				return err
+			}
+		}
+	}
+
+	if entry, exists := d.keyring.Entry(d.signer.KeyID()); exists {
+		if entry.RevokedFrom != "" || !entry.RevokedAt.IsZero() {
+			return ErrSigningKeyRevoked
+		}
+	}
+	return nil
+}
+
+// Revocations signed by keys that never became known are ignored
+func (d *dbApi) skipRevocations(pending *pendingRevocations) {
+	for _, record := range pending.published {
+		if _, exists := d.keyring.Entry(record.KeyID); exists {
+			log.Println("Warning: skipping the revocation signed by an unknown key", record.SignerKeyID)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+
+	"lsm-verification/signature"
+)
+
+const rotationRecordVersion = 1
+
+// Hands the signing over from the old key to the new one after the lseq.
+// Signed by both keys, so a validator trusting the old key learns the new one.
+type rotationRecord struct {
+	Version      int    `json:"version"`
+	ReplicaID    int32  `json:"replica_id"`
+	After        string `json:"after"`
+	OldKeyID     string `json:"old_key_id"`
+	NewKeyID     string `json:"new_key_id"`
+	NewAlgorithm string `json:"new_algorithm"`
+	NewPublicKey string `json:"new_public_key"`
+	RotatedAt    string `json:"rotated_at"`
+	OldSignature string `json:"old_signature,omitempty"`
+	NewSignature string `json:"new_signature,omitempty"`
+	// lseq of the rotation event, empty before it is written
+	eventLseq string
+}
+
+func (r *rotationRecord) signedDigest() (string, error) {
+	unsigned := *r
+	unsigned.OldSignature = ""
+	unsigned.NewSignature = ""
+	encoded, err := json.Marshal(unsigned)
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256(encoded)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+func (d *dbApi) readRotations(ctx context.Context) ([]*rotationRecord, error) {
+	items, err := d.readEventsByKey(ctx, d.recordKeys.rotation)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*rotationRecord, 0, len(items))
+	for _, item := range items {
+		// Other values of the key are hashed as entries
+		if !isRotationRecord(item.Value) {
+			log.Println("Warning: skipping a value of the rotation key that is not a rotation", item.Lseq)
+			continue
+		}
+		record := &rotationRecord{}
+		if err := json.Unmarshal([]byte(item.Value), record); err != nil {
+			return nil, ErrIncorrectRotationRecord
+		}
+		if record.ReplicaID != d.replicaId {
+			return nil, ErrIncorrectRotationRecord
+		}
+		record.eventLseq = item.Lseq
+		result = append(result, record)
+	}
+	return result, nil
+}
+
+// Rotations from an untrusted key are ignored, a rotation that
+// fails verification with a trusted key is an error. A rotation from a key
+// revoked at the rotation is ignored as well, otherwise the holder of a
+// leaked key could hand the signing over to a key of its own.
+// Returns whether the rotation is applied.
+func (d *dbApi) applyRotation(record *rotationRecord) (bool, error) {
+	oldEntry, exists := d.keyring.Entry(record.OldKeyID)
+	if !exists {
+		log.Println("Warning: skipping the rotation from an unknown key", record.OldKeyID)
+		return false, nil
+	}
+	if d.rotationRevoked(oldEntry, record) {
+		log.Printf("Warning: skipping the rotation from the revoked key %s after lseq '%s'\n", record.OldKeyID, record.After)
+		return false, nil
+	}
+
+	newVerifier, err := signature.LoadVerifier(record.NewPublicKey)
+	if err != nil {
+		return false, err
+	}
+	if newVerifier.KeyID() != record.NewKeyID || newVerifier.Algorithm() != record.NewAlgorithm {
+		return false, ErrIncorrectRotationRecord
+	}
+
+	digest, err := record.signedDigest()
+	if err != nil {
+		return false, err
+	}
+	if err := oldEntry.Verifier.Verify(record.OldSignature, digest); err != nil {
+		return false, err
+	}
+	if err := newVerifier.Verify(record.NewSignature, digest); err != nil {
+		return false, err
+	}
+
+	log.Printf("Key %s is rotated to %s after lseq '%s'\n", record.OldKeyID, record.NewKeyID, record.After)
+	return true, d.keyring.Rotate(record.OldKeyID, newVerifier, record.After)
+}
+
+// The old key is revoked at the lseq the rotation hands over after, or at the
+// lseq of the rotation event when it is in the same replica. The rotation time
+// is set by the signer, see signature.KeyringEntry.Revoked.
+func (d *dbApi) rotationRevoked(oldEntry *signature.KeyringEntry, record *rotationRecord) bool {
+	var rotatedAt *time.Time
+	if parsed, err := time.Parse(time.RFC3339Nano, record.RotatedAt); err == nil {
+		rotatedAt = &parsed
+	}
+	if oldEntry.Revoked(record.After, rotatedAt) {
+		return true
+	}
+	return record.eventLseq != "" && d.recordReplicaId == d.replicaId && oldEntry.Revoked(record.eventLseq, rotatedAt)
+}
+
+// Every rotation is checked against the revocations known before it, the
+// revocations of the keys it introduces are applied after it. A revocation
+// learned later that covers an applied rotation is an error, the keys
+// disagree about the history.
+func (d *dbApi) loadRotations(ctx context.Context, revocations *pendingRevocations) ([]*rotationRecord, error) {
+	log.Println("Loading the key rotations")
+	rotations, err := d.readRotations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []*rotationRecord{}
+	for _, record := range rotations {
+		ok, err := d.applyRotation(record)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			applied = append(applied, record)
+		}
+		if err := d.applyRevocations(revocations); err != nil {
+			return nil, err
+		}
+		if err := d.checkRotations(applied); err != nil {
+			return nil, err
+		}
+	}
+	return rotations, nil
+}
+
+func (d *dbApi) checkRotations(rotations []*rotationRecord) error {
+	for _, record := range rotations {
+		if oldEntry, exists := d.keyring.Entry(record.OldKeyID); exists && d.rotationRevoked(oldEntry, record) {
+			log.Printf("Key %s is revoked before its rotation to %s after lseq '%s'\n", record.OldKeyID, record.NewKeyID, record.After)
+			return ErrRevokedKeyRotation
+		}
+	}
+	return nil
+}
+
+// Emits the rotation record unless the signing key was already rotated to,
+// returns the rotations including the new one
+func (d *dbApi) rotateKey(ctx context.Context, previousSigner signature.Signer, rotations []*rotationRecord) ([]*rotationRecord, error) {
+	if previousSigner.KeyID() == d.signer.KeyID() {
+		return rotations, nil
+	}
+	for _, record := range rotations {
+		if record.NewKeyID == d.signer.KeyID() {
+			log.Println("Signing key is already rotated")
+			return rotations, nil
+		}
+	}
+
+	after := ""
+	lastValidatedValue, _, err := d.readLastValidated(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if lastValidatedValue != nil {
+		after = lastValidatedValue.Value
+	}
+
+	publicKey, err := signature.EncodePublicKey(d.signer.Verifier())
+	if err != nil {
+		return nil, err
+	}
+	record := &rotationRecord{
+		Version:      rotationRecordVersion,
+		ReplicaID:    d.replicaId,
+		After:        after,
+		OldKeyID:     previousSigner.KeyID(),
+		NewKeyID:     d.signer.KeyID(),
+		NewAlgorithm: d.signer.Algorithm(),
+		NewPublicKey: publicKey,
+		RotatedAt:    time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	digest, err := record.signedDigest()
+	if err != nil {
+		return nil, err
+	}
+	if record.OldSignature, err = previousSigner.Sign(digest); err != nil {
+		return nil, err
+	}
+	if record.NewSignature, err = d.signer.Sign(digest); err != nil {
+		return nil, err
+	}
+
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	// Validators would ignore the rotation
+	if oldEntry, exists := d.keyring.Entry(record.OldKeyID); exists && d.rotationRevoked(oldEntry, record) {
+		return nil, ErrRevokedKeyRotation
+	}
+	log.Printf("Rotating the signing key %s to %s after lseq '%s'\n", record.OldKeyID, record.NewKeyID, after)
+	if err := d.put(ctx, d.recordKeys.rotation, string(encoded)); err != nil {
+		return nil, err
+	}
+	if _, err := d.applyRotation(record); err != nil {
+		return nil, err
+	}
+	return append(rotations, record), nil
+}
+
+// Only a rotation record tells when a key was introduced
+func (d *dbApi) checkKeyAge(rotations []*rotationRecord, maxAgeDays int) {
+	if maxAgeDays <= 0 {
+		return
+	}
+	for _, record := range rotations {
+		if record.NewKeyID != d.signer.KeyID() {
+			continue
+		}
+		rotatedAt, err := time.Parse(time.RFC3339Nano, record.RotatedAt)
+		if err != nil {
+			log.Println("Warning: unable to parse the key rotation time", record.RotatedAt)
+			return
+		}
+		if time.Since(rotatedAt) > time.Duration(maxAgeDays)*24*time.Hour {
+			log.Printf("Warning: signing key %s is used since %s, it should be rotated every %d days\n", record.NewKeyID, record.RotatedAt, maxAgeDays)
+		}
+		return
+	}
+	log.Println("Warning: signing key was never rotated, its age is unknown")
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"os"
+
+	"lsm-verification/config"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Plaintext unless TLS is enabled. Without a CA bundle the server is
+// verified against the system roots, a client certificate enables mTLS.
+func transportCredentials(cfg config.TLS) (credentials.TransportCredentials, error) {
+	if !cfg.Enabled {
+		log.Println("Warning: the database connection is not encrypted")
+		return insecure.NewCredentials(), nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerName,
+	}
+
+	if cfg.CAFile != "" {
+		bundle, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, ErrIncorrectCABundle
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, ErrIncompleteClientCert
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+		log.Println("Using a client certificate")
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
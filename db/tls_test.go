@@ -0,0 +1,189 @@
+package db
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"lsm-verification/config"
+	"lsm-verification/fakedb"
+	"lsm-verification/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// Certificate signed by the parent, self-signed CA without one
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	result := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".pem"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	writeTestFile(t, result.certFile, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	writeTestFile(t, result.keyFile, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})))
+	return result
+}
+
+func writeTestFile(t *testing.T, path, contents string) {
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransportCredentials(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	client := newTestCert(t, "client", ca)
+	other := newTestCert(t, "other", ca)
+	garbage := filepath.Join(t.TempDir(), "garbage.pem")
+	writeTestFile(t, garbage, "not a certificate")
+
+	tests := []struct {
+		name     string
+		cfg      config.TLS
+		protocol string
+		err      error
+	}{
+		{name: "disabled", cfg: config.TLS{CAFile: ca.certFile}, protocol: "insecure"},
+		{name: "system roots", cfg: config.TLS{Enabled: true}, protocol: "tls"},
+		{name: "ca bundle", cfg: config.TLS{Enabled: true, CAFile: ca.certFile}, protocol: "tls"},
+		{name: "client certificate", cfg: config.TLS{Enabled: true, CAFile: ca.certFile, CertFile: client.certFile, KeyFile: client.keyFile}, protocol: "tls"},
+		{name: "malformed ca bundle", cfg: config.TLS{Enabled: true, CAFile: garbage}, err: ErrIncorrectCABundle},
+		{name: "certificate without key", cfg: config.TLS{Enabled: true, CertFile: client.certFile}, err: ErrIncompleteClientCert},
+		{name: "key without certificate", cfg: config.TLS{Enabled: true, KeyFile: client.keyFile}, err: ErrIncompleteClientCert},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			creds, err := transportCredentials(test.cfg)
+			if err != test.err {
+				t.Fatalf("error is %v, expected %v", err, test.err)
+			}
+			if err == nil && creds.Info().SecurityProtocol != test.protocol {
+				t.Fatalf("protocol is %s, expected %s", creds.Info().SecurityProtocol, test.protocol)
+			}
+		})
+	}
+
+	for name, cfg := range map[string]config.TLS{
+		"missing ca bundle":          {Enabled: true, CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		"key of another certificate": {Enabled: true, CertFile: client.certFile, KeyFile: other.keyFile},
+	} {
+		if _, err := transportCredentials(cfg); err == nil {
+			t.Errorf("%s: credentials are loaded", name)
+		}
+	}
+}
+
+// The server requires a client certificate signed by the CA
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	server := newTestCert(t, "localhost", ca)
+	client := newTestCert(t, "client", ca)
+	untrusted := newTestCert(t, "client", newTestCert(t, "other-ca", nil))
+
+	serverCert, err := tls.LoadX509KeyPair(server.certFile, server.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := x509.NewCertPool()
+	clients.AddCert(ca.cert)
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clients,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})))
+	srv := fakedb.NewServer(testReplica)
+	testPut(t, srv, "key", "value")
+	proto.RegisterLSeqDatabaseServer(grpcServer, srv)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+
+	for _, test := range []struct {
+		name string
+		cert *testCert
+		ok   bool
+	}{
+		{"trusted client", client, true},
+		{"client of another ca", untrusted, false},
+		{"no client certificate", nil, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := config.TLS{Enabled: true, CAFile: ca.certFile, ServerName: "localhost"}
+			if test.cert != nil {
+				cfg.CertFile, cfg.KeyFile = test.cert.certFile, test.cert.keyFile
+			}
+			creds, err := transportCredentials(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(creds))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err = proto.NewLSeqDatabaseClient(conn).SyncGet_(ctx, &proto.SyncGetRequest{ReplicaId: testReplica})
+			if (err == nil) != test.ok {
+				t.Fatalf("request error is %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+
+	"lsm-verification/config"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultRetryAttempts  = 5
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 5 * time.Second
+)
+
+// Several endpoints of the same replica are resolved under this scheme
+const endpointsScheme = "lsm-verification"
+
+// Reads are retried, writes are not: a lost response doesn't tell
+// whether the event was appended, the signer reconciles instead
+var idempotentMethods = map[string]bool{
+	"/lseqdb.LSeqDatabase/GetValue":         true,
+	"/lseqdb.LSeqDatabase/SeekGet":          true,
+	"/lseqdb.LSeqDatabase/GetReplicaEvents": true,
+	"/lseqdb.LSeqDatabase/SyncGet_":         true,
+}
+
+type retryPolicy struct {
+	attempts       int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+func newRetryPolicy(cfg config.Retry) retryPolicy {
+	policy := retryPolicy{
+		attempts:       defaultRetryAttempts,
+		initialBackoff: defaultInitialBackoff,
+		maxBackoff:     defaultMaxBackoff,
+	}
+	if cfg.MaxAttempts > 0 {
+		policy.attempts = cfg.MaxAttempts
+	}
+	if cfg.InitialBackoff > 0 {
+		policy.initialBackoff = time.Duration(cfg.InitialBackoff) * time.Millisecond
+	}
+	if cfg.MaxBackoff > 0 {
+		policy.maxBackoff = time.Duration(cfg.MaxBackoff) * time.Millisecond
+	}
+	return policy
+}
+
+func isRetryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// The database may come back, the request can be repeated later. The error
+// may be wrapped.
+func IsTransient(err error) bool {
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &grpcErr) {
+		return false
+	}
+	switch grpcErr.GRPCStatus().Code() {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// Exponential backoff with full jitter, bounded by the deadline of the request
+func retryInterceptor(policy retryPolicy) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !idempotentMethods[method] {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		backoff := policy.initialBackoff
+		for attempt := 1; ; attempt++ {
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			if err == nil || attempt >= policy.attempts || !isRetryable(err) {
+				return err
+			}
+
+			wait := time.Duration(rand.Int63n(int64(backoff)) + 1)
+			log.Printf("Retrying %s in %s after: %v\n", method, wait, err)
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(wait):
+			}
+
+			backoff *= 2
+			if backoff > policy.maxBackoff {
+				backoff = policy.maxBackoff
+			}
+		}
+	}
+}
+
+// Each endpoint is verified against its own host, the authority of the
+// shared target would match none of them. server_name overrides it.
+func endpointHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// Returns the dial target and the options for the endpoints. Several endpoints
+// are tried in order, the connection fails over to the next one.
+func transportOptions(addrs []string, cfg config.Db) (string, []grpc.DialOption) {
+	options := []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(retryInterceptor(newRetryPolicy(cfg.Retry))),
+		// Requests wait for a reconnect within their deadline instead of failing at once
+		grpc.WithDefaultCallOptions(grpc.WaitForReady(true)),
+	}
+	if cfg.Keepalive.Time > 0 {
+		options = append(options, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                time.Duration(cfg.Keepalive.Time) * time.Second,
+			Timeout:             time.Duration(cfg.Keepalive.Timeout) * time.Second,
+			PermitWithoutStream: true,
+		}))
+	}
+
+	if len(addrs) == 1 {
+		return addrs[0], options
+	}
+
+	log.Printf("Using %d database endpoints\n", len(addrs))
+	endpoints := manual.NewBuilderWithScheme(endpointsScheme)
+	state := resolver.State{}
+	for _, addr := range addrs {
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: addr, ServerName: endpointHost(addr)})
+	}
+	endpoints.InitialState(state)
+	return endpointsScheme + ":///replica", append(options, grpc.WithResolvers(endpoints))
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"lsm-verification/config"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Invoker failing with the codes in turn, then succeeding
+func failingInvoker(calls *int, codes ...codes.Code) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		*calls++
+		if *calls <= len(codes) {
+			return status.Error(codes[*calls-1], "failure")
+		}
+		return nil
+	}
+}
+
+func TestRetryInterceptor(t *testing.T) {
+	policy := retryPolicy{attempts: 3, initialBackoff: time.Millisecond, maxBackoff: time.Millisecond}
+	tests := []struct {
+		name   string
+		method string
+		codes  []codes.Code
+		calls  int
+		code   codes.Code
+	}{
+		{"read retried", "/lseqdb.LSeqDatabase/SeekGet", []codes.Code{codes.Unavailable, codes.Aborted}, 3, codes.OK},
+		{"attempts exhausted", "/lseqdb.LSeqDatabase/GetValue", []codes.Code{codes.Unavailable, codes.Unavailable, codes.ResourceExhausted}, 3, codes.ResourceExhausted},
+		{"permanent error", "/lseqdb.LSeqDatabase/GetReplicaEvents", []codes.Code{codes.NotFound}, 1, codes.NotFound},
+		// A lost response doesn't tell whether the event was appended
+		{"write not retried", "/lseqdb.LSeqDatabase/Put", []codes.Code{codes.Unavailable}, 1, codes.Unavailable},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			err := retryInterceptor(policy)(context.Background(), test.method, nil, nil, nil, failingInvoker(&calls, test.codes...))
+			if calls != test.calls {
+				t.Errorf("%d calls, expected %d", calls, test.calls)
+			}
+			if status.Code(err) != test.code {
+				t.Errorf("error is %v, expected %s", err, test.code)
+			}
+		})
+	}
+}
+
+func TestRetryStopsWithContext(t *testing.T) {
+	policy := retryPolicy{attempts: 10, initialBackoff: time.Hour, maxBackoff: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	calls := 0
+	err := retryInterceptor(policy)(ctx, "/lseqdb.LSeqDatabase/GetValue", nil, nil, nil, failingInvoker(&calls, codes.Unavailable, codes.Unavailable))
+	if calls != 1 || status.Code(err) != codes.Unavailable {
+		t.Fatalf("%d calls with %v, expected a single unavailable call", calls, err)
+	}
+}
+
+func TestRetryPolicyDefaults(t *testing.T) {
+	policy := newRetryPolicy(config.Retry{})
+	if policy.attempts != defaultRetryAttempts || policy.initialBackoff != defaultInitialBackoff || policy.maxBackoff != defaultMaxBackoff {
+		t.Fatalf("default policy is %+v", policy)
+	}
+	policy = newRetryPolicy(config.Retry{MaxAttempts: 2, InitialBackoff: 10, MaxBackoff: 20})
+	if policy.attempts != 2 || policy.initialBackoff != 10*time.Millisecond || policy.maxBackoff != 20*time.Millisecond {
+		t.Fatalf("configured policy is %+v", policy)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{status.Error(codes.Unavailable, "down"), true},
+		{status.Error(codes.DeadlineExceeded, "slow"), true},
+		{fmt.Errorf("signing: %w", status.Error(codes.ResourceExhausted, "busy")), true},
+		{status.Error(codes.NotFound, "missing"), false},
+		{status.Error(codes.InvalidArgument, "bad"), false},
+		{ErrRecordMismatch, false},
+		{errors.New("other"), false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		if transient := IsTransient(test.err); transient != test.transient {
+			t.Errorf("%v: transient is %t, expected %t", test.err, transient, test.transient)
+		}
+	}
+}
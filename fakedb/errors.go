@@ -0,0 +1,5 @@
+package fakedb
+
+import "errors"
+
+var ErrMalformedLseq = errors.New("malformed lseq, should be '<seq>@<replica>'")
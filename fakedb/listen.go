@@ -0,0 +1,54 @@
+package fakedb
+
+import (
+	"context"
+	"log"
+	"net"
+
+	"lsm-verification/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const bufconnSize = 1 << 20
+
+// Address to pass as the server address when dialing a bufconn listener.
+const BufconnAddress = "bufnet"
+
+func Serve(lis net.Listener, srv *Server) *grpc.Server {
+	grpcServer := grpc.NewServer()
+	proto.RegisterLSeqDatabaseServer(grpcServer, srv)
+
+	go func() {
+		if err := grpcServer.Serve(lis); err != nil {
+			log.Println("Fake database server stopped: ", err)
+		}
+	}()
+	return grpcServer
+}
+
+func ListenAndServe(addr string, srv *Server) (*grpc.Server, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return Serve(lis, srv), nil
+}
+
+// Starts the server in-process. The returned options make a client dial
+// BufconnAddress through the in-memory listener.
+func ServeBufconn(srv *Server) (*grpc.Server, []grpc.DialOption) {
+	lis := bufconn.Listen(bufconnSize)
+	grpcServer := Serve(lis, srv)
+
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}
+	return grpcServer, []grpc.DialOption{
+		grpc.WithContextDialer(dialer),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}
+}
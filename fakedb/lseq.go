@@ -0,0 +1,31 @@
+package fakedb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Lseqs are zero-padded so that the lexicographic order matches the order
+// in which the events were appended to the replica.
+func FormatLseq(replicaId int32, seq uint64) string {
+	return fmt.Sprintf("%020d@%d", seq, replicaId)
+}
+
+func ParseLseq(lseq string) (int32, uint64, error) {
+	split := strings.Split(lseq, "@")
+	if len(split) != 2 {
+		return 0, 0, ErrMalformedLseq
+	}
+
+	seq, err := strconv.ParseUint(split[0], 10, 64)
+	if err != nil {
+		return 0, 0, ErrMalformedLseq
+	}
+	replicaId, err := strconv.ParseInt(split[1], 10, 32)
+	if err != nil {
+		return 0, 0, ErrMalformedLseq
+	}
+
+	return int32(replicaId), seq, nil
+}
@@ -0,0 +1,205 @@
+package fakedb
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+
+	"lsm-verification/proto"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type replica struct {
+	lastSeq uint64
+	items   []*proto.DBItems_DbItem
+	latest  map[string]*proto.DBItems_DbItem
+}
+
+func newReplica() *replica {
+	return &replica{
+		latest: make(map[string]*proto.DBItems_DbItem),
+	}
+}
+
+func (r *replica) append(item *proto.DBItems_DbItem) {
+	idx := sort.Search(len(r.items), func(i int) bool {
+		return r.items[i].Lseq >= item.Lseq
+	})
+	if idx < len(r.items) && r.items[idx].Lseq == item.Lseq {
+		return
+	}
+
+	r.items = append(r.items, nil)
+	copy(r.items[idx+1:], r.items[idx:])
+	r.items[idx] = item
+
+	if latest, exists := r.latest[item.Key]; !exists || latest.Lseq < item.Lseq {
+		r.latest[item.Key] = item
+	}
+}
+
+// Returns events starting from the first lseq not less than start
+// (or greater than start when inclusive is false), filtered by key.
+func (r *replica) events(start *string, inclusive bool, key *string, limit *uint32) []*proto.DBItems_DbItem {
+	idx := 0
+	if start != nil {
+		idx = sort.Search(len(r.items), func(i int) bool {
+			if inclusive {
+				return r.items[i].Lseq >= *start
+			}
+			return r.items[i].Lseq > *start
+		})
+	}
+
+	result := []*proto.DBItems_DbItem{}
+	for ; idx < len(r.items); idx++ {
+		if limit != nil && uint32(len(result)) >= *limit {
+			break
+		}
+		item := r.items[idx]
+		if key != nil && item.Key != *key {
+			continue
+		}
+		result = append(result, &proto.DBItems_DbItem{
+			Lseq:  item.Lseq,
+			Key:   item.Key,
+			Value: item.Value,
+		})
+	}
+
+	return result
+}
+
+// In-memory implementation of the LSeqDatabase service. Put appends to the
+// server's own replica, SyncPut_ imports events of any other replica.
+type Server struct {
+	proto.UnimplementedLSeqDatabaseServer
+
+	mu       sync.Mutex
+	selfId   int32
+	replicas map[int32]*replica
+}
+
+func NewServer(selfReplicaId int32) *Server {
+	return &Server{
+		selfId:   selfReplicaId,
+		replicas: make(map[int32]*replica),
+	}
+}
+
+func (s *Server) getReplica(replicaId int32) *replica {
+	r, exists := s.replicas[replicaId]
+	if !exists {
+		r = newReplica()
+		s.replicas[replicaId] = r
+	}
+	return r
+}
+
+func (s *Server) GetValue(ctx context.Context, in *proto.ReplicaKey) (*proto.Value, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	replicaId := s.selfId
+	if in.ReplicaId != nil {
+		replicaId = *in.ReplicaId
+	}
+
+	item, exists := s.getReplica(replicaId).latest[in.Key]
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "key %q not found in replica %d", in.Key, replicaId)
+	}
+
+	return &proto.Value{
+		Value: item.Value,
+		Lseq:  item.Lseq,
+	}, nil
+}
+
+func (s *Server) Put(ctx context.Context, in *proto.PutRequest) (*proto.LSeq, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.getReplica(s.selfId)
+	r.lastSeq++
+	item := &proto.DBItems_DbItem{
+		Lseq:  FormatLseq(s.selfId, r.lastSeq),
+		Key:   in.Key,
+		Value: in.Value,
+	}
+	r.append(item)
+
+	return &proto.LSeq{Lseq: item.Lseq}, nil
+}
+
+func (s *Server) SeekGet(ctx context.Context, in *proto.SeekGetRequest) (*proto.DBItems, error) {
+	replicaId, _, err := ParseLseq(in.Lseq)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return &proto.DBItems{
+		Items:     s.getReplica(replicaId).events(&in.Lseq, true, in.Key, in.Limit),
+		ReplicaId: replicaId,
+	}, nil
+}
+
+func (s *Server) GetReplicaEvents(ctx context.Context, in *proto.EventsRequest) (*proto.DBItems, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return &proto.DBItems{
+		Items:     s.getReplica(in.ReplicaId).events(in.Lseq, false, in.Key, in.Limit),
+		ReplicaId: in.ReplicaId,
+	}, nil
+}
+
+func (s *Server) SyncGet_(ctx context.Context, in *proto.SyncGetRequest) (*proto.LSeq, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.getReplica(in.ReplicaId)
+	if len(r.items) == 0 {
+		return nil, status.Errorf(codes.NotFound, "replica %d is empty", in.ReplicaId)
+	}
+
+	return &proto.LSeq{Lseq: r.items[len(r.items)-1].Lseq}, nil
+}
+
+func (s *Server) SyncPut_(ctx context.Context, in *proto.DBItems) (*empty.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.getReplica(in.ReplicaId)
+	for _, item := range in.Items {
+		if item == nil {
+			return nil, status.Error(codes.InvalidArgument, "empty DB item")
+		}
+		replicaId, seq, err := ParseLseq(item.Lseq)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if replicaId != in.ReplicaId {
+			return nil, status.Errorf(codes.InvalidArgument, "lseq %s does not belong to replica %d", item.Lseq, in.ReplicaId)
+		}
+
+		r.append(&proto.DBItems_DbItem{
+			Lseq:  item.Lseq,
+			Key:   item.Key,
+			Value: item.Value,
+		})
+		if seq > r.lastSeq {
+			r.lastSeq = seq
+		}
+	}
+	log.Printf("Synced %d items into replica %d\n", len(in.Items), in.ReplicaId)
+
+	return &empty.Empty{}, nil
+}
@@ -0,0 +1,227 @@
+package fakedb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+
+	"lsm-verification/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func put(t *testing.T, srv *Server, key, value string) string {
+	lseq, err := srv.Put(context.Background(), &proto.PutRequest{Key: key, Value: value})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lseq.Lseq
+}
+
+func lseqs(items []*proto.DBItems_DbItem) []string {
+	result := []string{}
+	for _, item := range items {
+		result = append(result, item.Lseq)
+	}
+	return result
+}
+
+func limit(n uint32) *uint32 {
+	return &n
+}
+
+func TestPut(t *testing.T) {
+	srv := NewServer(2)
+	first := put(t, srv, "a", "1")
+	second := put(t, srv, "a", "2")
+	if first != FormatLseq(2, 1) || second != FormatLseq(2, 2) {
+		t.Fatalf("lseqs are %s, %s", first, second)
+	}
+
+	value, err := srv.GetValue(context.Background(), &proto.ReplicaKey{Key: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.Value != "2" || value.Lseq != second {
+		t.Fatalf("latest value is %q at %s", value.Value, value.Lseq)
+	}
+
+	other := int32(3)
+	_, err = srv.GetValue(context.Background(), &proto.ReplicaKey{Key: "a", ReplicaId: &other})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("value of another replica: %v", err)
+	}
+}
+
+func TestGetReplicaEventsPaging(t *testing.T) {
+	srv := NewServer(1)
+	for i := 0; i < 7; i++ {
+		put(t, srv, fmt.Sprintf("key%d", i%2), fmt.Sprint(i))
+	}
+
+	// The start lseq is exclusive, so the last lseq of a page starts the next one
+	paged := []string{}
+	var start *string
+	for {
+		items, err := srv.GetReplicaEvents(context.Background(), &proto.EventsRequest{ReplicaId: 1, Lseq: start, Limit: limit(3)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items.Items) > 3 {
+			t.Fatalf("page has %d items", len(items.Items))
+		}
+		if len(items.Items) == 0 {
+			break
+		}
+		paged = append(paged, lseqs(items.Items)...)
+		start = &items.Items[len(items.Items)-1].Lseq
+	}
+	all, err := srv.GetReplicaEvents(context.Background(), &proto.EventsRequest{ReplicaId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Items) != 7 || !reflect.DeepEqual(paged, lseqs(all.Items)) {
+		t.Fatalf("paged %v, all %v", paged, lseqs(all.Items))
+	}
+
+	key := "key1"
+	filtered, err := srv.GetReplicaEvents(context.Background(), &proto.EventsRequest{ReplicaId: 1, Key: &key, Lseq: &paged[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{FormatLseq(1, 4), FormatLseq(1, 6)}
+	if !reflect.DeepEqual(lseqs(filtered.Items), expected) {
+		t.Fatalf("events of %s after %s are %v", key, paged[1], lseqs(filtered.Items))
+	}
+}
+
+func TestSeekGet(t *testing.T) {
+	srv := NewServer(1)
+	for i := 0; i < 5; i++ {
+		put(t, srv, fmt.Sprintf("key%d", i%2), fmt.Sprint(i))
+	}
+
+	// The seek lseq is inclusive
+	items, err := srv.SeekGet(context.Background(), &proto.SeekGetRequest{Lseq: FormatLseq(1, 2), Limit: limit(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items.ReplicaId != 1 || !reflect.DeepEqual(lseqs(items.Items), []string{FormatLseq(1, 2), FormatLseq(1, 3)}) {
+		t.Fatalf("seek returned %v of replica %d", lseqs(items.Items), items.ReplicaId)
+	}
+
+	key := "key0"
+	items, err = srv.SeekGet(context.Background(), &proto.SeekGetRequest{Lseq: FormatLseq(1, 2), Key: &key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lseqs(items.Items), []string{FormatLseq(1, 3), FormatLseq(1, 5)}) {
+		t.Fatalf("seek of %s returned %v", key, lseqs(items.Items))
+	}
+
+	// Search stays within the replica of the lseq
+	items, err = srv.SeekGet(context.Background(), &proto.SeekGetRequest{Lseq: FormatLseq(2, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items.Items) != 0 {
+		t.Fatalf("seek in an empty replica returned %v", lseqs(items.Items))
+	}
+
+	_, err = srv.SeekGet(context.Background(), &proto.SeekGetRequest{Lseq: "malformed"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("seek of a malformed lseq: %v", err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	srv := NewServer(1)
+	put(t, srv, "own", "1")
+
+	_, err := srv.SyncGet_(context.Background(), &proto.SyncGetRequest{ReplicaId: 2})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("head of an empty replica: %v", err)
+	}
+
+	synced := &proto.DBItems{ReplicaId: 2, Items: []*proto.DBItems_DbItem{
+		{Lseq: FormatLseq(2, 3), Key: "a", Value: "3"},
+		{Lseq: FormatLseq(2, 1), Key: "a", Value: "1"},
+		{Lseq: FormatLseq(2, 1), Key: "a", Value: "duplicate"},
+	}}
+	if _, err := srv.SyncPut_(context.Background(), synced); err != nil {
+		t.Fatal(err)
+	}
+	head, err := srv.SyncGet_(context.Background(), &proto.SyncGetRequest{ReplicaId: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Lseq != FormatLseq(2, 3) {
+		t.Fatalf("head of replica 2 is %s", head.Lseq)
+	}
+	events, err := srv.GetReplicaEvents(context.Background(), &proto.EventsRequest{ReplicaId: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lseqs(events.Items), []string{FormatLseq(2, 1), FormatLseq(2, 3)}) || events.Items[0].Value != "1" {
+		t.Fatalf("replica 2 events are %v", events.Items)
+	}
+
+	// Replicas are kept apart
+	own, err := srv.GetReplicaEvents(context.Background(), &proto.EventsRequest{ReplicaId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lseqs(own.Items), []string{FormatLseq(1, 1)}) {
+		t.Fatalf("replica 1 events are %v", lseqs(own.Items))
+	}
+
+	wrongReplica := &proto.DBItems{ReplicaId: 2, Items: []*proto.DBItems_DbItem{{Lseq: FormatLseq(3, 1), Key: "a"}}}
+	if _, err := srv.SyncPut_(context.Background(), wrongReplica); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("sync of an lseq of another replica: %v", err)
+	}
+
+	// Put continues after the synced events of the own replica
+	ownSync := &proto.DBItems{ReplicaId: 1, Items: []*proto.DBItems_DbItem{{Lseq: FormatLseq(1, 5), Key: "b"}}}
+	if _, err := srv.SyncPut_(context.Background(), ownSync); err != nil {
+		t.Fatal(err)
+	}
+	if lseq := put(t, srv, "c", "6"); lseq != FormatLseq(1, 6) {
+		t.Fatalf("put after sync appended at %s", lseq)
+	}
+}
+
+func TestServeBufconn(t *testing.T) {
+	srv := NewServer(1)
+	grpcServer, options := ServeBufconn(srv)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.Dial(BufconnAddress, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := proto.NewLSeqDatabaseClient(conn)
+
+	lseq, err := client.Put(context.Background(), &proto.PutRequest{Key: "a", Value: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := client.GetValue(context.Background(), &proto.ReplicaKey{Key: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.Lseq != lseq.Lseq || value.Value != "1" {
+		t.Fatalf("value over bufconn is %q at %s", value.Value, value.Lseq)
+	}
+}
@@ -1,75 +1,574 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"lsm-verification/calculations"
 	"lsm-verification/config"
 	"lsm-verification/db"
+	"lsm-verification/metrics"
+	"lsm-verification/models"
 	"lsm-verification/orchestrator"
+	"lsm-verification/report"
+	"lsm-verification/verifier"
+	"os"
+	"os/signal"
 	"path"
+	"syscall"
 	"time"
 )
 
-func signLoop(orch orchestrator.Orchestrator, cfg config.Config) error {
-	for {
-		err := orch.SignNew()
+const (
+	defaultShutdownTimeout  = 30 * time.Second
+	defaultMonitorInterval  = 10 * time.Second
+	defaultCoverageInterval = time.Minute
+	// Delay before a failed signer of a replica list starts again
+	defaultRestartDelay = 30 * time.Second
+)
+
+// No batch is started after ctx is done, the current one gets
+// the shutdown timeout to be written
+// Returns nil once ctx is done, stats may be nil
+func signLoop(ctx context.Context, orch orchestrator.Orchestrator, cfg config.Config, stats *metrics.Replica) error {
+	shutdownTimeout := defaultShutdownTimeout
+	if cfg.ShutdownTimeout > 0 {
+		shutdownTimeout = time.Duration(cfg.ShutdownTimeout) * time.Second
+	}
+	coverageInterval := defaultCoverageInterval
+	if cfg.CoverageInterval > 0 {
+		coverageInterval = time.Duration(cfg.CoverageInterval) * time.Second
+	}
+	batchCtx, cancel := withShutdownTimeout(ctx, shutdownTimeout)
+	defer cancel()
+
+	var coverageAt time.Time
+	for ctx.Err() == nil {
+		err := orch.SignNew(batchCtx)
 		if err == nil {
+			stats.AddBatch()
+		}
+		if (err == nil || err == orchestrator.ErrNoNewEntities) && time.Since(coverageAt) >= coverageInterval {
+			if coverage, err := orch.Coverage(batchCtx); err != nil {
+				log.Println(replicaPrefix(cfg.Env.Db.ReplicaID)+"Failed to read the coverage: ", err)
+			} else {
+				stats.SetCoverage(coverage)
+				logCoverage(replicaPrefix(cfg.Env.Db.ReplicaID), coverage)
+			}
+			coverageAt = time.Now()
+		}
+		if err == nil || ctx.Err() != nil {
 			continue
 		}
-		if cfg.SkipErrors {
-			log.Println("Skipping error error: ", err)
+		if db.IsTransient(err) {
+			// A write may be lost, the records past the last validated lseq are adopted
+			stats.AddError(err)
+			log.Println(replicaPrefix(cfg.Env.Db.ReplicaID)+"Database is unavailable, retrying: ", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Duration(cfg.SignTimeout) * time.Second):
+			}
+			if err := orch.Reconcile(batchCtx); err != nil && !db.IsTransient(err) {
+				return fmt.Errorf("failed to reconcile the signed history: %w", err)
+			}
 			continue
 		}
 		if err == orchestrator.ErrNoNewEntities {
-			time.Sleep(time.Duration(cfg.SignTimeout) * time.Second)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Duration(cfg.SignTimeout) * time.Second):
+			}
 		} else {
-			log.Fatalln(err)
+			return err
 		}
 	}
+	log.Println(replicaPrefix(cfg.Env.Db.ReplicaID) + "Signing is stopped")
+	return nil
+}
+
+// Writes the key changes of the signer, restores the MMR and adopts the
+// records written before a restart
+func prepareSigner(ctx context.Context, dbState db.DbState, orch orchestrator.Orchestrator, calculator calculations.HashCalculator) error {
+	if err := dbState.StartSigning(ctx); err != nil {
+		return err
+	}
+	if err := resumeMMR(ctx, dbState, orch, calculator); err != nil {
+		return err
+	}
+	if err := orch.Reconcile(ctx); err != nil {
+		return fmt.Errorf("failed to reconcile the signed history: %w", err)
+	}
+	// An adopted record moves the last validated lseq
+	return resumeMMR(ctx, dbState, orch, calculator)
+}
+
+// The MMR continues from the peaks stored with the last validated record, a
+// history signed without them is replayed from the genesis
+func resumeMMR(ctx context.Context, dbState db.DbState, orch orchestrator.Orchestrator, calculator calculations.HashCalculator) error {
+	mmr, ok := calculator.(calculations.MMRCalculator)
+	if !ok {
+		return nil
+	}
+	lastValidated, err := dbState.GetLastValidated(ctx)
+	if err != nil {
+		return err
+	}
+	if lastValidated == nil || mmr.Resume(lastValidated) {
+		return nil
+	}
+	log.Println("Last validated record has no MMR peaks, restoring the MMR from the signed history")
+	if _, err := validateDb(ctx, orch, nil, nil, nil); err != nil {
+		return fmt.Errorf("signed history is not valid: %w", err)
+	}
+	return nil
 }
 
-func validateDb(orch orchestrator.Orchestrator, cfg config.Config) (bool, *string, error) {
-	lastValidated, lastHash, err := orch.ValidateFromLseq(nil, nil)
-	for {
+// The owner of a replica signs it, an attestor signs a replica it doesn't own
+func checkAttestation(cfg config.Config) {
+	attested := cfg.Attestation.ReplicaID != nil && *cfg.Attestation.ReplicaID != cfg.Env.Db.ReplicaID
+	if cfg.RunMode == config.RunModeAttest && !attested {
+		log.Fatalln("Attestation replica is not set or is the attested replica: ", cfg.Env.Db.ReplicaID)
+	}
+	if cfg.RunMode == config.RunModeSign && attested {
+		log.Fatalln("Attestations are signed in mode: ", config.RunModeAttest)
+	}
+}
+
+// The signers keep running without metrics
+func serveMetrics(ctx context.Context, cfg config.Config, registry *metrics.Registry) {
+	if err := metrics.Serve(ctx, cfg.Metrics.Address, registry); err != nil {
+		log.Println("Failed to serve the metrics: ", err)
+	}
+}
+
+// Context that is cancelled the timeout after the parent is done
+func withShutdownTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-parent.Done():
+			log.Println("Shutting down, waiting for the current batch")
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-time.After(timeout):
+			log.Println("Shutdown timeout, aborting the current batch")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Errors are already handled by the failure policy of the orchestrator.
+// Validates from the anchor, nil for the genesis, until the first signed
+// lseq at or after to, or the end without it. Returns the last valid lseq.
+func validateDb(ctx context.Context, orch orchestrator.Orchestrator, anchorLseq, anchorHash, to *string) (*string, error) {
+	lastValidated, lastHash := anchorLseq, anchorHash
+	for to == nil || lastValidated == nil || *lastValidated < *to {
+		nextValidated, nextHash, err := orch.ValidateFromLseq(ctx, lastValidated, lastHash)
 		if err == orchestrator.ErrNoNewEntities {
-			return true, lastValidated, nil
+			return nextValidated, nil
+		}
+		if err != nil {
+			return lastValidated, err
+		}
+		lastValidated, lastHash = nextValidated, nextHash
+	}
+	return lastValidated, nil
+}
+
+// The record verified by the previous run, if any, has to be unchanged
+func validationResult(ctx context.Context, orch orchestrator.Orchestrator, lseqRange config.ValidationRange, previous *verifier.State) models.ValidationResult {
+	result := models.ValidationResult{
+		From: lseqRange.From,
+		To:   lseqRange.To,
+	}
+	var validationErr *orchestrator.ValidationError
+	if previous != nil {
+		err := orch.VerifyAnchor(ctx, previous.Record())
+		if errors.As(err, &validationErr) {
+			result.Status = models.ValidationInvalid
+			result.Failure = &validationErr.Failure
+			return result
 		}
-		if err == orchestrator.ErrValidationFailed {
-			return false, lastValidated, err
+		if err != nil {
+			result.Status = models.ValidationError
+			result.Error = "failed to check the previously verified lseq: " + err.Error()
+			return result
 		}
+	}
+
+	var anchorLseq, anchorHash, to *string
+	if lseqRange.To != "" {
+		to = &lseqRange.To
+	}
+	var err error
+	if lseqRange.From != "" {
+		anchorLseq, anchorHash, err = orch.AnchorAt(ctx, lseqRange.From)
 		if err != nil {
-			if !cfg.SkipErrors {
-				return false, lastValidated, err
-			}
-			log.Println("Skipping error error: ", err)
+			result.Status = models.ValidationError
+			result.Error = "failed to find the signed record to start from: " + err.Error()
+			return result
+		}
+		if anchorLseq != nil {
+			result.AnchorLseq = *anchorLseq
+		}
+	}
+
+	lastLseq, err := validateDb(ctx, orch, anchorLseq, anchorHash, to)
+	if lastLseq != nil {
+		result.LastValidLseq = *lastLseq
+	}
+	// Batches may be validated past the range, the entries up to its start
+	// belong to an earlier run of the same orchestrator
+	for _, untrusted := range orch.UntrustedRanges() {
+		if (to == nil || untrusted.From <= *to) && untrusted.To > lseqRange.From {
+			result.Untrusted = append(result.Untrusted, untrusted)
+		}
+	}
+	for _, entry := range orch.Skipped() {
+		end := entry.Lseq
+		if entry.To > end {
+			end = entry.To
+		}
+		if (to == nil || entry.Lseq <= *to) && end > lseqRange.From {
+			result.Skipped = append(result.Skipped, entry)
+		}
+	}
+
+	if errors.As(err, &validationErr) {
+		result.LastValidLseq = ""
+		if validationErr.LastValid != nil {
+			result.LastValidLseq = *validationErr.LastValid
+		}
+		if to == nil || result.LastValidLseq < *to {
+			result.Status = models.ValidationInvalid
+			result.Failure = &validationErr.Failure
+			return result
 		}
-		lastValidated, lastHash, err = orch.ValidateFromLseq(lastValidated, lastHash)
+		log.Println("Failure is past the validated range: ", validationErr)
+		err = nil
+	}
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		result.Status = models.ValidationIncomplete
+		result.Error = "validation is interrupted: " + err.Error()
+	case err != nil:
+		result.Status = models.ValidationError
+		result.Error = err.Error()
+	case len(result.Untrusted) > 0 || len(result.Skipped) > 0:
+		result.Status = models.ValidationIncomplete
+	default:
+		result.Status = models.ValidationValid
+	}
+	return result
+}
+
+func loadVerifierState(cfg config.Config) *verifier.State {
+	if cfg.VerifierState == "" {
+		return nil
+	}
+	state, err := verifier.Load(cfg.VerifierState, cfg.Env.Db.ReplicaID)
+	if err != nil {
+		log.Fatalln("Failed to load the verifier state: ", err)
+	}
+	if state == nil {
+		log.Println("No verifier state, validating from the genesis")
 	}
+	return state
+}
+
+// State at the last valid lseq of the result, the previous one if the state
+// doesn't move. It only moves forward, and not past skipped or untrusted entries.
+func nextVerifierState(cfg config.Config, orch orchestrator.Orchestrator, previous *verifier.State, result models.ValidationResult) (*verifier.State, error) {
+	if result.Failure != nil || len(result.Skipped) > 0 || len(result.Untrusted) > 0 {
+		return previous, nil
+	}
+	if result.LastValidLseq == "" || (previous != nil && result.LastValidLseq <= previous.Lseq) {
+		return previous, nil
+	}
+
+	// An interrupted run keeps its progress
+	record, err := orch.SignedRecord(context.Background(), result.LastValidLseq)
+	if err != nil {
+		return previous, err
+	}
+	if record == nil {
+		return previous, db.ErrLastValidatedIsMissing
+	}
+	return verifier.NewState(cfg.Env.Db.ReplicaID, record), nil
+}
+
+func saveVerifierState(cfg config.Config, previous, state *verifier.State) error {
+	if cfg.VerifierState == "" || state == previous {
+		return nil
+	}
+	log.Println("Saving the verifier state at lseq: ", state.Lseq)
+	return verifier.Save(cfg.VerifierState, state)
+}
+
+func writeReport(cfg config.Config, result models.ValidationResult) error {
+	if cfg.Report.Format == "" {
+		return nil
+	}
+	return report.WriteFile(cfg.Report.Path, cfg.Report.Format, result)
+}
+
+// Validation resumes from the verified state unless the range sets the start.
+// The MMR is rebuilt from the genesis.
+func startRange(cfg config.Config, state *verifier.State) config.ValidationRange {
+	lseqRange := cfg.ValidationRange
+	if state != nil && lseqRange.From == "" && cfg.HashCalculator != config.HashCalculatorMMR {
+		log.Println("Resuming validation from the verified lseq: ", state.Lseq)
+		lseqRange.From = state.Lseq
+	}
+	return lseqRange
+}
+
+// Validates the new entries every interval until ctx is done, from the last
+// valid lseq. The last verified state is kept in memory without a state file
+// and has to stay unchanged. A broken chain is reported on every round until
+// it is repaired.
+func monitorLoop(ctx context.Context, orch orchestrator.Orchestrator, cfg config.Config) int {
+	interval := defaultMonitorInterval
+	if cfg.Monitor.Interval > 0 {
+		interval = time.Duration(cfg.Monitor.Interval) * time.Second
+	}
+	maxLag := time.Duration(cfg.Monitor.MaxLag) * time.Second
+
+	state := loadVerifierState(cfg)
+	lseqRange := startRange(cfg, state)
+	worst := models.ValidationValid
+	for ctx.Err() == nil {
+		result := validationResult(ctx, orch, lseqRange, state)
+		if ctx.Err() != nil {
+			break
+		}
+
+		next, err := nextVerifierState(cfg, orch, state, result)
+		if err == nil {
+			err = saveVerifierState(cfg, state, next)
+		}
+		if err != nil {
+			log.Println("Failed to save the verifier state: ", err)
+		}
+		state = next
+		if result.LastValidLseq != "" {
+			lseqRange.From = result.LastValidLseq
+		}
+
+		addCoverage(ctx, orch, &result)
+		if result.Coverage != nil && maxLag > 0 && time.Duration(result.Coverage.UnsignedSeconds)*time.Second > maxLag {
+			result.Status = models.ValidationLagging
+		}
+
+		logValidationResult(result)
+		if err := writeReport(cfg, result); err != nil {
+			log.Println("Failed to write the report: ", err)
+		}
+		worst = report.WorseStatus(worst, result.Status)
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+		}
+	}
+	log.Println("Monitoring is stopped, worst status: ", worst)
+	return report.ExitCode(worst)
+}
+
+// Coverage of a consistent result, a failure to read it is only logged
+func addCoverage(ctx context.Context, orch orchestrator.Orchestrator, result *models.ValidationResult) {
+	if result.Failure != nil || result.Status == models.ValidationError || ctx.Err() != nil {
+		return
+	}
+	coverage, err := orch.Coverage(ctx)
+	if err != nil {
+		log.Println("Failed to read the coverage: ", err)
+		return
+	}
+	result.Coverage = &coverage
+}
+
+func logCoverage(prefix string, coverage models.Coverage) {
+	log.Printf(prefix+"Coverage: %d of %d entries signed to lseq %s, %d unsigned entries after it, the first for %ds, head %s at distance %d\n",
+		coverage.SignedEntries, coverage.TotalEntries, coverage.LastSignedLseq, coverage.UnsignedTail,
+		coverage.UnsignedSeconds, coverage.HeadLseq, coverage.HeadDistance)
+}
+
+func logValidationResult(result models.ValidationResult) {
+	if result.Coverage != nil {
+		logCoverage("", *result.Coverage)
+	}
+	for _, lseqRange := range result.Untrusted {
+		log.Printf("Signatures of lseqs from %s to %s are made by the revoked key %s, they need re-attestation\n", lseqRange.From, lseqRange.To, lseqRange.KeyID)
+	}
+	for _, entry := range result.Skipped {
+		log.Printf("Lseq %s is skipped (%s): %s\n", entry.Lseq, entry.Class, entry.Error)
+		if entry.From != "" {
+			log.Printf("Lseqs from %s to %s are not verified\n", entry.From, entry.To)
+		}
+	}
+
+	switch result.Status {
+	case models.ValidationValid:
+		log.Println("Database is valid to lseq: ", result.LastValidLseq)
+	case models.ValidationInvalid:
+		failure := result.Failure
+		log.Printf("Database is not valid on lseq %s (%s): %s\n", failure.Lseq, failure.Category, failure.Error)
+		if result.LastValidLseq != "" {
+			log.Println("Database is valid to lseq: ", result.LastValidLseq)
+		}
+		if failure.Key != "" {
+			log.Printf("Entry of lseq %s: key %q, value %q\n", failure.Lseq, failure.Key, failure.Value)
+		}
+		if failure.ExpectedHash != "" {
+			log.Printf("Signed hash %s, computed hash %s\n", failure.ExpectedHash, failure.ComputedHash)
+		}
+	case models.ValidationLagging:
+		log.Printf("Signer is behind, lseq %s is unsigned for %ds, valid to lseq %s\n", result.Coverage.FirstUnsignedLseq, result.Coverage.UnsignedSeconds, result.LastValidLseq)
+	case models.ValidationIncomplete:
+		if result.Error != "" {
+			log.Println(result.Error)
+		}
+		log.Println("Database is consistent to lseq, but not completely validated: ", result.LastValidLseq)
+	default:
+		log.Println("Validation failed with an error: ", result.Error)
+	}
+}
+
+func createHashCalculator(cfg config.Config) calculations.HashCalculator {
+	switch cfg.HashCalculator {
+	case "", config.HashCalculatorChain:
+		return calculations.CreateHashCalculator()
+	case config.HashCalculatorMMR:
+		return calculations.CreateMMRCalculator()
+	}
+	log.Fatalln("Hash calculator unsupported: ", cfg.HashCalculator)
+	return nil
+}
+
+func createFailurePolicy(cfg config.Config) *orchestrator.FailurePolicy {
+	rules := map[orchestrator.FailureClass]orchestrator.FailureRule{}
+	for class, rule := range cfg.FailurePolicy {
+		rules[orchestrator.FailureClass(class)] = orchestrator.FailureRule{
+			Action:  orchestrator.FailureAction(rule.Action),
+			Retries: rule.Retries,
+		}
+	}
+	policy, err := orchestrator.NewFailurePolicy(rules)
+	if err != nil {
+		log.Fatalln("Failure policy is not valid: ", err)
+	}
+	return policy
+}
+
+func createOrchestrator(dbState db.DbState, calculator calculations.HashCalculator, cfg config.Config) orchestrator.Orchestrator {
+	policy := createFailurePolicy(cfg)
+	if cfg.Checkpoint.Every <= 0 && cfg.Checkpoint.Interval <= 0 {
+		return orchestrator.CreateOrchestrator(dbState, calculator, policy)
+	}
+	return orchestrator.CreateCheckpointOrchestrator(dbState, calculator, orchestrator.CheckpointPolicy{
+		Every:       cfg.Checkpoint.Every,
+		Interval:    time.Duration(cfg.Checkpoint.Interval) * time.Second,
+		StoreHashes: cfg.Checkpoint.StoreHashes,
+	}, policy)
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// Returns the exit code, deferred calls run before the exit
+func run() int {
 	cfg := config.LoadConfig(path.Join("config", "config.yaml"))
-	dbState, err := db.CreateDbState(cfg)
+	if cfg.Report.Format != "" {
+		if err := report.CheckFormat(cfg.Report.Format); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if cfg.ValidationRange.From != "" && cfg.ValidationRange.To != "" && cfg.ValidationRange.To < cfg.ValidationRange.From {
+		log.Fatalln("Validation range ends before it starts")
+	}
+	if cfg.ValidationRange.From != "" && cfg.HashCalculator == config.HashCalculatorMMR {
+		// The MMR is rebuilt from every entry, a signed root alone can't be extended
+		log.Fatalln("Validation from an lseq is not supported by the MMR calculator")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if len(cfg.Quorum.Signers) > 0 {
+		if cfg.RunMode != config.RunModeValidation || len(cfg.Replicas) > 0 {
+			log.Fatalln("Quorum is only supported in mode: ", config.RunModeValidation)
+		}
+		log.Println("Running in mode: ", cfg.RunMode)
+		return validateQuorum(ctx, cfg)
+	}
+	if len(cfg.Replicas) > 0 {
+		log.Println("Running in mode: ", cfg.RunMode)
+		switch cfg.RunMode {
+		case config.RunModeValidation:
+			return validateReplicas(ctx, cfg)
+		case config.RunModeSign, config.RunModeAttest:
+			return signReplicas(ctx, cfg)
+		}
+		log.Fatalln("Replicas are not supported in mode: ", cfg.RunMode)
+	}
+
+	checkAttestation(cfg)
+	dbState, err := db.CreateDbState(ctx, cfg)
 	if err != nil {
 		log.Fatalln("Failed to load db: ", err)
 	}
 	defer dbState.CloseConnection()
 
-	hashCalculator := calculations.CreateHashCalculator()
-	orch := orchestrator.CreateOrchestrator(dbState, hashCalculator)
+	hashCalculator := createHashCalculator(cfg)
+	orch := createOrchestrator(dbState, hashCalculator, cfg)
 	log.Println("Running in mode: ", cfg.RunMode)
 	if cfg.RunMode == config.RunModeValidation {
-		valid, lastLseq, err := validateDb(orch, cfg)
-		if err != nil {
+		state := loadVerifierState(cfg)
+		result := validationResult(ctx, orch, startRange(cfg, state), state)
+		addCoverage(ctx, orch, &result)
+		logValidationResult(result)
+		exitCode := report.ExitCode(result.Status)
+		if cfg.VerifierState != "" {
+			next, err := nextVerifierState(cfg, orch, state, result)
+			if err == nil {
+				err = saveVerifierState(cfg, state, next)
+			}
+			if err != nil {
+				log.Println("Failed to save the verifier state: ", err)
+				exitCode = report.ExitError
+			}
+		}
+		if err := writeReport(cfg, result); err != nil {
+			log.Println("Failed to write the report: ", err)
+			return report.ExitError
+		}
+		log.Println("Task is done")
+		return exitCode
+	} else if cfg.RunMode == config.RunModeMonitor {
+		return monitorLoop(ctx, orch, cfg)
+	} else if cfg.RunMode == config.RunModeProve {
+		return prove(ctx, dbState, cfg)
+	} else if cfg.RunMode == config.RunModeVerifyProof {
+		return verifyProof(ctx, dbState, cfg)
+	} else if cfg.RunMode == config.RunModeSign || cfg.RunMode == config.RunModeAttest {
+		if err := prepareSigner(ctx, dbState, orch, hashCalculator); err != nil {
 			log.Fatalln(err)
 		}
-		if valid {
-			log.Println("Database is valid to lseq: ", lastLseq)
-		} else {
-			log.Println("Database is not valid on lseq: ", lastLseq)
+		var stats *metrics.Replica
+		if cfg.Metrics.Address != "" {
+			registry := metrics.NewRegistry()
+			stats = registry.Replica(cfg.Env.Db.ReplicaID)
+			go serveMetrics(ctx, cfg, registry)
 		}
-	} else if cfg.RunMode == config.RunModeSign {
-		err = signLoop(orch, cfg)
+		stats.SetRunning(true)
+		err = signLoop(ctx, orch, cfg, stats)
+		stats.SetRunning(false)
 		if err != nil {
 			log.Fatalln(err)
 		}
@@ -77,4 +576,5 @@ func main() {
 		log.Fatalln("Run mode unsupported")
 	}
 	log.Println("Task is done")
+	return report.ExitValid
 }
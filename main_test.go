@@ -0,0 +1,1041 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"lsm-verification/config"
+	"lsm-verification/db"
+	"lsm-verification/fakedb"
+	"lsm-verification/models"
+	"lsm-verification/orchestrator"
+	"lsm-verification/proto"
+	"lsm-verification/report"
+	"lsm-verification/signature"
+
+	"google.golang.org/grpc"
+	"gopkg.in/yaml.v2"
+)
+
+const testReplica int32 = 1
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+type testKey struct {
+	private string
+	public  string
+	id      string
+}
+
+func newKey(t *testing.T) testKey {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDer, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDer, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := testKey{
+		private: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDer})),
+		public:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer})),
+	}
+	verifier, err := signature.LoadVerifier(key.public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.id = verifier.KeyID()
+	return key
+}
+
+// Serves the fake database on a local port until the test ends
+func serve(t *testing.T, srv *fakedb.Server) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := fakedb.Serve(lis, srv)
+	t.Cleanup(grpcServer.Stop)
+	return lis.Addr().String()
+}
+
+func testConfig(addr string, key testKey) config.Config {
+	cfg := config.Config{}
+	cfg.Env.Db.ServerAddress = addr
+	cfg.Env.Db.ReplicaID = testReplica
+	cfg.Env.Keys.PublicKey = key.public
+	cfg.Env.Keys.PrivateKey = key.private
+	return cfg
+}
+
+func putEntries(t *testing.T, srv *fakedb.Server, prefix string, count int) {
+	for i := 0; i < count; i++ {
+		request := &proto.PutRequest{Key: fmt.Sprintf("%s%d", prefix, i), Value: fmt.Sprintf("value %d", i)}
+		if _, err := srv.Put(context.Background(), request); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func events(t *testing.T, srv *fakedb.Server) []*proto.DBItems_DbItem {
+	items, err := srv.GetReplicaEvents(context.Background(), &proto.EventsRequest{ReplicaId: testReplica})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return items.Items
+}
+
+// Copies the replica into a new database, edit changes an event or drops
+// it by returning nil
+func copyDb(t *testing.T, srv *fakedb.Server, edit func(item *proto.DBItems_DbItem) *proto.DBItems_DbItem) *fakedb.Server {
+	items := []*proto.DBItems_DbItem{}
+	for _, item := range events(t, srv) {
+		if edit != nil {
+			item = edit(item)
+		}
+		if item != nil {
+			items = append(items, item)
+		}
+	}
+	copied := fakedb.NewServer(testReplica)
+	if _, err := copied.SyncPut_(context.Background(), &proto.DBItems{ReplicaId: testReplica, Items: items}); err != nil {
+		t.Fatal(err)
+	}
+	return copied
+}
+
+// Signs every entry of the replica
+func sign(t *testing.T, cfg config.Config) {
+	ctx := context.Background()
+	cfg.RunMode = config.RunModeSign
+	dbState, err := db.CreateDbState(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbState.CloseConnection()
+
+	calculator := createHashCalculator(cfg)
+	orch := createOrchestrator(dbState, calculator, cfg)
+	if err := prepareSigner(ctx, dbState, orch, calculator); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		err := orch.SignNew(ctx)
+		if err == orchestrator.ErrNoNewEntities {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func validate(cfg config.Config) models.ValidationResult {
+	cfg.RunMode = config.RunModeValidation
+	return validateConcurrently(context.Background(), cfg, "")
+}
+
+func checkStatus(t *testing.T, result models.ValidationResult, status models.ValidationStatus, category orchestrator.FailureClass) {
+	t.Helper()
+	if result.Status != status {
+		t.Fatalf("status is %s, expected %s: %s", result.Status, status, resultSummary(result))
+	}
+	failure := ""
+	if result.Failure != nil {
+		failure = result.Failure.Category
+	}
+	if failure != string(category) {
+		t.Fatalf("failure is %q, expected %q: %s", failure, category, resultSummary(result))
+	}
+}
+
+func TestSignAndValidate(t *testing.T) {
+	srv := fakedb.NewServer(testReplica)
+	cfg := testConfig(serve(t, srv), newKey(t))
+	putEntries(t, srv, "key", 10)
+	sign(t, cfg)
+	// A user key under the validation prefix is an entry as any other
+	putEntries(t, srv, db.DefaultValidationPrefix+"user", 3)
+	putEntries(t, srv, "key", 5)
+	sign(t, cfg)
+
+	result := validate(cfg)
+	checkStatus(t, result, models.ValidationValid, "")
+	last := events(t, srv)
+	if result.LastValidLseq == "" || result.LastValidLseq > last[len(last)-1].Lseq {
+		t.Fatalf("last valid lseq is %q", result.LastValidLseq)
+	}
+	if code := report.ExitCode(result.Status); code != report.ExitValid {
+		t.Fatalf("exit code is %d", code)
+	}
+
+	// The unsigned tail is counted, not derived from the lseqs
+	putEntries(t, srv, "tail", 4)
+	coverage := validate(cfg).Coverage
+	if coverage == nil || coverage.SignedEntries != 18 || coverage.UnsignedTail != 4 {
+		t.Fatalf("coverage is %+v", coverage)
+	}
+	distance := int64(0)
+	for _, item := range events(t, srv) {
+		if item.Lseq > coverage.LastSignedLseq {
+			distance++
+		}
+	}
+	if coverage.HeadDistance != distance {
+		t.Fatalf("head distance is %d, expected %d", coverage.HeadDistance, distance)
+	}
+}
+
+// Validators keep the private key in the environment, they don't need to own
+// the replica since they never write to it
+func TestValidateReplicaOfAnotherServer(t *testing.T) {
+	srv := fakedb.NewServer(testReplica)
+	cfg := testConfig(serve(t, srv), newKey(t))
+	putEntries(t, srv, "key", 10)
+	sign(t, cfg)
+
+	other := fakedb.NewServer(testReplica + 1)
+	synced := &proto.DBItems{ReplicaId: testReplica, Items: events(t, srv)}
+	if _, err := other.SyncPut_(context.Background(), synced); err != nil {
+		t.Fatal(err)
+	}
+	head := len(events(t, other))
+	cfg.Env.Db.ServerAddress = serve(t, other)
+	checkStatus(t, validate(cfg), models.ValidationValid, "")
+	if len(events(t, other)) != head {
+		t.Fatal("validation wrote to the replica")
+	}
+
+	// A signer has to own the replica
+	cfg.RunMode = config.RunModeSign
+	dbState, err := db.CreateDbState(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbState.CloseConnection()
+	if err := dbState.StartSigning(context.Background()); err != db.ErrWrongRecordReplica {
+		t.Fatalf("signer of a replica the server doesn't own: %v", err)
+	}
+
+	// An empty attestation replica is checked on the first write
+	attestationReplica := int32(testReplica + 2)
+	cfg.RunMode = config.RunModeAttest
+	cfg.Attestation.ReplicaID = &attestationReplica
+	attestor, err := db.CreateDbState(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer attestor.CloseConnection()
+	if err := attestor.StartSigning(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	orch := createOrchestrator(attestor, createHashCalculator(cfg), cfg)
+	if err := orch.SignNew(context.Background()); err != db.ErrWrongRecordReplica {
+		t.Fatalf("attestor of a replica the server doesn't own: %v", err)
+	}
+}
+
+// Record of the entry with the lseq in the default namespace
+func recordKey(lseq string) string {
+	return db.DefaultValidationPrefix + lseq
+}
+
+func editRecord(item *proto.DBItems_DbItem, edit func(record map[string]interface{})) *proto.DBItems_DbItem {
+	record := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(item.Value), &record); err != nil {
+		return item
+	}
+	edit(record)
+	encoded, _ := json.Marshal(record)
+	return &proto.DBItems_DbItem{Lseq: item.Lseq, Key: item.Key, Value: string(encoded)}
+}
+
+// First entry from the lseq, not a validation record or service key
+func firstEntryAfter(t *testing.T, srv *fakedb.Server, lseq string) string {
+	for _, item := range events(t, srv) {
+		if item.Lseq >= lseq && !strings.HasPrefix(item.Key, db.DefaultValidationPrefix) {
+			return item.Lseq
+		}
+	}
+	t.Fatal("no entry after lseq ", lseq)
+	return ""
+}
+
+type tamperTest struct {
+	name string
+	// entry is the tampered entry, next is the entry after it
+	edit     func(entry, next string, item *proto.DBItems_DbItem) *proto.DBItems_DbItem
+	category orchestrator.FailureClass
+}
+
+// Every edit of a signed replica is found at or before the tampered entry
+func runTamperTests(t *testing.T, tests []tamperTest) {
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := fakedb.NewServer(testReplica)
+			key := newKey(t)
+			cfg := testConfig(serve(t, srv), key)
+			putEntries(t, srv, "key", 5)
+			sign(t, cfg)
+			putEntries(t, srv, "key", 5)
+			sign(t, cfg)
+			checkStatus(t, validate(cfg), models.ValidationValid, "")
+
+			entry := firstEntryAfter(t, srv, fakedb.FormatLseq(testReplica, 12))
+			next := firstEntryAfter(t, srv, fakedb.FormatLseq(testReplica, 13))
+			tampered := copyDb(t, srv, func(item *proto.DBItems_DbItem) *proto.DBItems_DbItem {
+				return test.edit(entry, next, item)
+			})
+			result := validate(testConfig(serve(t, tampered), key))
+			checkStatus(t, result, models.ValidationInvalid, test.category)
+			if result.LastValidLseq >= entry {
+				t.Fatalf("last valid lseq %s is not before the tampered lseq %s", result.LastValidLseq, entry)
+			}
+		})
+	}
+}
+
+// Edits the record of the tampered entry
+func editEntryRecord(edit func(record map[string]interface{})) func(entry, next string, item *proto.DBItems_DbItem) *proto.DBItems_DbItem {
+	return func(entry, next string, item *proto.DBItems_DbItem) *proto.DBItems_DbItem {
+		if item.Key != recordKey(entry) {
+			return item
+		}
+		return editRecord(item, edit)
+	}
+}
+
+func TestTamperedAlgorithm(t *testing.T) {
+	runTamperTests(t, []tamperTest{
+		{
+			name: "downgraded signature algorithm",
+			edit: editEntryRecord(func(record map[string]interface{}) {
+				record["signature_algorithm"] = signature.AlgorithmRSAPSS
+			}),
+			category: orchestrator.FailureBadSignature,
+		},
+	})
+}
+
+func TestTamperedRecords(t *testing.T) {
+	runTamperTests(t, []tamperTest{
+		{
+			name: "changed value",
+			edit: func(entry, next string, item *proto.DBItems_DbItem) *proto.DBItems_DbItem {
+				if item.Lseq == entry {
+					return &proto.DBItems_DbItem{Lseq: item.Lseq, Key: item.Key, Value: "forged"}
+				}
+				return item
+			},
+			category: orchestrator.FailureHashMismatch,
+		},
+		{
+			name: "dropped entry",
+			edit: func(entry, next string, item *proto.DBItems_DbItem) *proto.DBItems_DbItem {
+				if item.Lseq == entry {
+					return nil
+				}
+				return item
+			},
+			category: orchestrator.FailureHashMismatch,
+		},
+		{
+			name: "changed record hash",
+			edit: editEntryRecord(func(record map[string]interface{}) {
+				record["hash"] = strings.Repeat("0", len(record["hash"].(string)))
+			}),
+			category: orchestrator.FailureBadSignature,
+		},
+		{
+			name: "changed signature",
+			edit: editEntryRecord(func(record map[string]interface{}) {
+				record["signature"] = strings.Repeat("0", len(record["signature"].(string)))
+			}),
+			category: orchestrator.FailureBadSignature,
+		},
+		{
+			name: "downgraded hash scheme",
+			edit: editEntryRecord(func(record map[string]interface{}) {
+				record["scheme"] = models.HashSchemeConcat
+			}),
+			category: orchestrator.FailureBadSignature,
+		},
+		{
+			name: "record of the next entry",
+			edit: func(entry, next string, item *proto.DBItems_DbItem) *proto.DBItems_DbItem {
+				if item.Key == recordKey(entry) {
+					return nil
+				}
+				if item.Key == recordKey(next) {
+					return &proto.DBItems_DbItem{Lseq: item.Lseq, Key: recordKey(entry), Value: item.Value}
+				}
+				return item
+			},
+			category: orchestrator.FailureMalformedRecord,
+		},
+	})
+}
+
+// Signs 10 entries with the old key and 10 more after rotating to the new one
+func rotatedDb(t *testing.T, oldKey, newKey testKey) *fakedb.Server {
+	srv := fakedb.NewServer(testReplica)
+	addr := serve(t, srv)
+	putEntries(t, srv, "key", 10)
+	sign(t, testConfig(addr, oldKey))
+
+	rotated := testConfig(addr, newKey)
+	rotated.Env.Keys.PreviousPrivateKey = oldKey.private
+	putEntries(t, srv, "key", 10)
+	sign(t, rotated)
+	return srv
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, newKey, rogueKey := newKey(t), newKey(t), newKey(t)
+	srv := rotatedDb(t, oldKey, newKey)
+	// The validator only trusts the old key and learns the new one
+	cfg := testConfig(serve(t, srv), oldKey)
+	cfg.Env.Keys.PrivateKey = ""
+	checkStatus(t, validate(cfg), models.ValidationValid, "")
+
+	rotationKey := db.DefaultValidationPrefix + "rotation"
+	t.Run("forged rotation", func(t *testing.T) {
+		tampered := copyDb(t, srv, func(item *proto.DBItems_DbItem) *proto.DBItems_DbItem {
+			if item.Key != rotationKey {
+				return item
+			}
+			return editRecord(item, func(record map[string]interface{}) {
+				record["new_key_id"] = rogueKey.id
+				record["new_public_key"] = rogueKey.public
+			})
+		})
+		forgedCfg := cfg
+		forgedCfg.Env.Db.ServerAddress = serve(t, tampered)
+		checkStatus(t, validate(forgedCfg), models.ValidationError, "")
+	})
+
+	t.Run("untrusted key", func(t *testing.T) {
+		// The rotation from an unknown key is ignored
+		rogueCfg := testConfig(cfg.Env.Db.ServerAddress, rogueKey)
+		rogueCfg.Env.Keys.PrivateKey = ""
+		checkStatus(t, validate(rogueCfg), models.ValidationInvalid, orchestrator.FailureBadSignature)
+	})
+
+	t.Run("signer restart", func(t *testing.T) {
+		// The rotation is written once
+		rotated := testConfig(cfg.Env.Db.ServerAddress, newKey)
+		rotated.Env.Keys.PreviousPrivateKey = oldKey.private
+		putEntries(t, srv, "more", 5)
+		sign(t, rotated)
+		rotations := 0
+		for _, item := range events(t, srv) {
+			if item.Key == rotationKey {
+				rotations++
+			}
+		}
+		if rotations != 1 {
+			t.Fatalf("%d rotation records", rotations)
+		}
+		checkStatus(t, validate(cfg), models.ValidationValid, "")
+	})
+}
+
+func writeRevocations(t *testing.T, revocations ...config.Revocation) string {
+	encoded, err := yaml.Marshal(config.RevocationList{Revocations: revocations})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "revocations.yaml")
+	if err := os.WriteFile(path, encoded, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKeyRevocation(t *testing.T) {
+	oldKey, newKey, leakedKey := newKey(t), newKey(t), newKey(t)
+	srv := rotatedDb(t, oldKey, newKey)
+	cfg := testConfig(serve(t, srv), oldKey)
+	cfg.Env.Keys.PrivateKey = ""
+
+	t.Run("validators don't write", func(t *testing.T) {
+		head := len(events(t, srv))
+		validatorCfg := testConfig(cfg.Env.Db.ServerAddress, newKey)
+		validatorCfg.Env.Keys.PreviousPrivateKey = leakedKey.private
+		validatorCfg.RevocationList = writeRevocations(t, config.Revocation{KeyID: oldKey.id, RevokedFrom: fakedb.FormatLseq(testReplica, 1000)})
+		validate(validatorCfg)
+		if len(events(t, srv)) != head {
+			t.Fatal("validation wrote to the replica")
+		}
+	})
+
+	t.Run("rotation from a revoked key", func(t *testing.T) {
+		// The old key leaked before the rotation, the rotation may hand the
+		// signing over to the holder of the leaked key
+		revokedFrom := firstEntryAfter(t, srv, fakedb.FormatLseq(testReplica, 5))
+		revokedCfg := cfg
+		revokedCfg.RevocationList = writeRevocations(t, config.Revocation{KeyID: oldKey.id, RevokedFrom: revokedFrom})
+		// The new key stays unknown
+		result := validate(revokedCfg)
+		checkStatus(t, result, models.ValidationInvalid, orchestrator.FailureBadSignature)
+		if result.LastValidLseq >= revokedFrom {
+			t.Fatalf("last valid lseq %s is not before the revocation at %s", result.LastValidLseq, revokedFrom)
+		}
+	})
+
+	t.Run("revocation after the rotation", func(t *testing.T) {
+		revokedCfg := cfg
+		revokedCfg.RevocationList = writeRevocations(t, config.Revocation{KeyID: oldKey.id, RevokedFrom: fakedb.FormatLseq(testReplica, 1000)})
+		checkStatus(t, validate(revokedCfg), models.ValidationValid, "")
+	})
+
+	t.Run("published revocation", func(t *testing.T) {
+		published := copyDb(t, srv, nil)
+		addr := serve(t, published)
+		// The old key is not used after the rotation
+		signerCfg := testConfig(addr, newKey)
+		signerCfg.RevocationList = writeRevocations(t, config.Revocation{KeyID: oldKey.id, RevokedFrom: fakedb.FormatLseq(testReplica, 1000)})
+		for i := 0; i < 2; i++ {
+			putEntries(t, published, "more", 5)
+			sign(t, signerCfg)
+		}
+		revocations := 0
+		for _, item := range events(t, published) {
+			if item.Key == db.DefaultValidationPrefix+"revocation" {
+				revocations++
+			}
+		}
+		if revocations != 1 {
+			t.Fatalf("%d revocation records", revocations)
+		}
+
+		validatorCfg := cfg
+		validatorCfg.Env.Db.ServerAddress = addr
+		checkStatus(t, validate(validatorCfg), models.ValidationValid, "")
+	})
+}
+
+// Signatures after the cut-off are valid but untrusted
+func TestUntrustedRange(t *testing.T) {
+	key := newKey(t)
+	srv := fakedb.NewServer(testReplica)
+	cfg := testConfig(serve(t, srv), key)
+	putEntries(t, srv, "key", 10)
+	sign(t, cfg)
+
+	revokedFrom := firstEntryAfter(t, srv, fakedb.FormatLseq(testReplica, 5))
+	cfg.RevocationList = writeRevocations(t, config.Revocation{KeyID: key.id, RevokedFrom: revokedFrom})
+	result := validate(cfg)
+	checkStatus(t, result, models.ValidationIncomplete, "")
+	if len(result.Untrusted) != 1 || result.Untrusted[0].From != revokedFrom || result.Untrusted[0].KeyID != key.id {
+		t.Fatalf("untrusted ranges are %+v, expected one from %s", result.Untrusted, revokedFrom)
+	}
+	if code := report.ExitCode(result.Status); code != report.ExitIncomplete {
+		t.Fatalf("exit code is %d", code)
+	}
+}
+
+func writePublicKey(t *testing.T, key testKey) string {
+	path := filepath.Join(t.TempDir(), "public.pem")
+	if err := os.WriteFile(path, []byte(key.public), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// A revoked key can't revoke the key that revokes it
+func TestRevocationByRevokedKey(t *testing.T) {
+	key, leakedKey := newKey(t), newKey(t)
+	srv := fakedb.NewServer(testReplica)
+	addr := serve(t, srv)
+	putEntries(t, srv, "key", 10)
+	sign(t, testConfig(addr, key))
+
+	// The holder of the leaked key publishes a revocation of the signing key
+	leakedCfg := testConfig(addr, leakedKey)
+	leakedCfg.RunMode = config.RunModeSign
+	leakedCfg.RevocationList = writeRevocations(t, config.Revocation{KeyID: key.id, RevokedFrom: fakedb.FormatLseq(testReplica, 1)})
+	dbState, err := db.CreateDbState(context.Background(), leakedCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dbState.StartSigning(context.Background())
+	dbState.CloseConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig(addr, key)
+	cfg.Env.Keys.PrivateKey = ""
+	cfg.Keyring = []config.KeyringEntry{{PublicKeyFile: writePublicKey(t, leakedKey)}}
+	t.Run("revoked key", func(t *testing.T) {
+		revokedCfg := cfg
+		revokedCfg.RevocationList = writeRevocations(t, config.Revocation{KeyID: leakedKey.id, RevokedFrom: fakedb.FormatLseq(testReplica, 1)})
+		checkStatus(t, validate(revokedCfg), models.ValidationValid, "")
+	})
+	t.Run("trusted key", func(t *testing.T) {
+		checkStatus(t, validate(cfg), models.ValidationIncomplete, "")
+	})
+	t.Run("revocation authority", func(t *testing.T) {
+		authorityCfg := cfg
+		authorityCfg.RevocationList = writeRevocations(t, config.Revocation{KeyID: leakedKey.id, RevokedFrom: fakedb.FormatLseq(testReplica, 1)})
+		authorityCfg.RevocationAuthorities = []string{leakedKey.id}
+		checkStatus(t, validate(authorityCfg), models.ValidationIncomplete, "")
+	})
+}
+
+// The records of one signer fill whole pages of the other
+func TestSignersSharingReplica(t *testing.T) {
+	srv := fakedb.NewServer(testReplica)
+	addr := serve(t, srv)
+	batchSize := uint32(5)
+	first := testConfig(addr, newKey(t))
+	first.Db.BatchSize = &batchSize
+	first.SignerNamespaces = []string{"second"}
+	second := testConfig(addr, newKey(t))
+	second.Db.BatchSize = &batchSize
+	second.Namespace = "second"
+
+	for round := 0; round < 2; round++ {
+		// A page of user data under record keys of the signer
+		for i := 0; i < int(batchSize)+1; i++ {
+			request := &proto.PutRequest{Key: db.DefaultValidationPrefix + fakedb.FormatLseq(7, uint64(i)), Value: fmt.Sprintf("user value %d", i)}
+			if _, err := srv.Put(context.Background(), request); err != nil {
+				t.Fatal(err)
+			}
+		}
+		putEntries(t, srv, fmt.Sprintf("round%d-", round), 12)
+		sign(t, first)
+		sign(t, second)
+	}
+
+	lastEntry := ""
+	for _, item := range events(t, srv) {
+		if !strings.HasPrefix(item.Key, db.DefaultValidationPrefix) {
+			lastEntry = item.Lseq
+		}
+	}
+	for _, cfg := range []config.Config{first, second} {
+		result := validate(cfg)
+		checkStatus(t, result, models.ValidationValid, "")
+		if result.LastValidLseq != lastEntry {
+			t.Fatalf("namespace %q: last valid lseq is %s, expected %s", cfg.Namespace, result.LastValidLseq, lastEntry)
+		}
+	}
+}
+
+// User data under the keys of the signer is hashed unless it is a record of
+// the kind of the key
+func TestUserDataInReservedKeys(t *testing.T) {
+	srv := fakedb.NewServer(testReplica)
+	cfg := testConfig(serve(t, srv), newKey(t))
+	putEntries(t, srv, "key", 5)
+	sign(t, cfg)
+
+	userData := []*proto.PutRequest{
+		{Key: db.DefaultValidationPrefix + "asd", Value: "not an lseq"},
+		{Key: recordKey(fakedb.FormatLseq(testReplica, 1000)), Value: "not a record"},
+		{Key: db.DefaultValidationPrefix + "rotation", Value: `{"version":1}`},
+		{Key: db.DefaultValidationPrefix + "revocation", Value: "not a revocation"},
+	}
+	for _, request := range userData {
+		if _, err := srv.Put(context.Background(), request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	putEntries(t, srv, "more", 5)
+	sign(t, cfg)
+	result := validate(cfg)
+	checkStatus(t, result, models.ValidationValid, "")
+	// The signer resumes after its own last validated lseq
+	for _, item := range events(t, srv) {
+		if item.Key == "more4" && result.LastValidLseq != item.Lseq {
+			t.Fatalf("last valid lseq is %s, expected %s", result.LastValidLseq, item.Lseq)
+		}
+	}
+
+	for _, request := range userData {
+		t.Run(request.Key, func(t *testing.T) {
+			edited := copyDb(t, srv, func(item *proto.DBItems_DbItem) *proto.DBItems_DbItem {
+				if item.Key == request.Key && item.Value == request.Value {
+					return &proto.DBItems_DbItem{Lseq: item.Lseq, Key: item.Key, Value: request.Value + " edited"}
+				}
+				return item
+			})
+			editedCfg := cfg
+			editedCfg.Env.Db.ServerAddress = serve(t, edited)
+			checkStatus(t, validate(editedCfg), models.ValidationInvalid, orchestrator.FailureHashMismatch)
+		})
+	}
+}
+
+// Number of requests to the server by method name
+type requestCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (c *requestCounter) count(method string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[method]
+}
+
+func serveCounted(t *testing.T, srv *fakedb.Server) (string, *requestCounter) {
+	counter := &requestCounter{counts: map[string]int{}}
+	interceptor := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		counter.mu.Lock()
+		counter.counts[filepath.Base(info.FullMethod)]++
+		counter.mu.Unlock()
+		return handler(ctx, req)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(interceptor))
+	proto.RegisterLSeqDatabaseServer(grpcServer, srv)
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+	return lis.Addr().String(), counter
+}
+
+// Attestations of a replica are stored in the replica of the attestor, next
+// to its own entries
+func TestAttestation(t *testing.T) {
+	attested := fakedb.NewServer(testReplica)
+	putEntries(t, attested, "key", 150)
+	attestor := fakedb.NewServer(testReplica + 1)
+	synced := &proto.DBItems{ReplicaId: testReplica, Items: events(t, attested)}
+	if _, err := attestor.SyncPut_(context.Background(), synced); err != nil {
+		t.Fatal(err)
+	}
+	putEntries(t, attestor, "own", 50)
+
+	addr, counter := serveCounted(t, attestor)
+	cfg := testConfig(addr, newKey(t))
+	attestationReplica := testReplica + 1
+	cfg.Attestation.ReplicaID = &attestationReplica
+	sign(t, cfg)
+	putEntries(t, attestor, "more own", 50)
+
+	getValues := counter.count("GetValue")
+	result := validate(cfg)
+	checkStatus(t, result, models.ValidationValid, "")
+	if last := events(t, attested); result.LastValidLseq != last[len(last)-1].Lseq {
+		t.Fatalf("last valid lseq is %s", result.LastValidLseq)
+	}
+	// The records are paged, not read one by one
+	if requests := counter.count("GetValue") - getValues; requests > 10 {
+		t.Fatalf("%d GetValue requests to validate", requests)
+	}
+	if len(events(t, attested)) != 150 {
+		t.Fatal("attestor wrote to the attested replica")
+	}
+}
+
+func TestQuorum(t *testing.T) {
+	srv := fakedb.NewServer(testReplica)
+	addr := serve(t, srv)
+	names := []string{"a", "b", "c", "rogue"}
+	keys := map[string]testKey{}
+	for _, name := range names {
+		keys[name] = newKey(t)
+	}
+	signerCfg := func(addr, name string) config.Config {
+		cfg := testConfig(addr, keys[name])
+		cfg.Namespace = name
+		cfg.SignerNamespaces = names
+		return cfg
+	}
+	quorumCfg := func(addr string, quorum ...string) config.Config {
+		cfg := testConfig(addr, keys["a"])
+		cfg.Env.Keys = config.Keys{}
+		cfg.Quorum = config.Quorum{K: 2}
+		for _, name := range quorum {
+			cfg.Quorum.Signers = append(cfg.Quorum.Signers, config.Signer{Name: name, Namespace: name, PublicKeyFile: writePublicKey(t, keys[name])})
+		}
+		return cfg
+	}
+
+	putEntries(t, srv, "key", 10)
+	sign(t, signerCfg(addr, "a"))
+	sign(t, signerCfg(addr, "b"))
+	putEntries(t, srv, "key", 5)
+	sign(t, signerCfg(addr, "c"))
+	if code := validateQuorum(context.Background(), quorumCfg(addr, "a", "b", "c")); code != report.ExitValid {
+		t.Fatalf("exit code of the honest signers is %d, expected %d", code, report.ExitValid)
+	}
+
+	// A broken record of c after the lseq attested by a and b costs c its
+	// vote only
+	late := firstEntryAfter(t, srv, fakedb.FormatLseq(testReplica, 30))
+	broken := copyDb(t, srv, func(item *proto.DBItems_DbItem) *proto.DBItems_DbItem {
+		if item.Key == db.DefaultValidationPrefix+"c/"+late {
+			return editRecord(item, func(record map[string]interface{}) {
+				record["hash"] = strings.Repeat("0", len(record["hash"].(string)))
+			})
+		}
+		return item
+	})
+	brokenAddr := serve(t, broken)
+	if result := validate(signerCfg(brokenAddr, "c")); result.Failure == nil || result.Failure.Lseq != late {
+		t.Fatalf("signer c doesn't fail on lseq %s: %s", late, resultSummary(result))
+	}
+	if code := validateQuorum(context.Background(), quorumCfg(brokenAddr, "a", "b", "c")); code != report.ExitValid {
+		t.Fatalf("exit code with a late failure is %d, expected %d", code, report.ExitValid)
+	}
+
+	// The rogue signer signs the entries replaced after the honest signers
+	forged := firstEntryAfter(t, srv, fakedb.FormatLseq(testReplica, 5))
+	tampered := copyDb(t, srv, func(item *proto.DBItems_DbItem) *proto.DBItems_DbItem {
+		if item.Lseq == forged {
+			return &proto.DBItems_DbItem{Lseq: item.Lseq, Key: item.Key, Value: "forged"}
+		}
+		return item
+	})
+	tamperedAddr := serve(t, tampered)
+	sign(t, signerCfg(tamperedAddr, "rogue"))
+	if code := validateQuorum(context.Background(), quorumCfg(tamperedAddr, "a", "b", "rogue")); code != report.ExitInvalid {
+		t.Fatalf("exit code of the forged history is %d, expected %d", code, report.ExitInvalid)
+	}
+}
+
+// A later run finds a history that no longer passes through the verified record
+func TestVerifierState(t *testing.T) {
+	key := newKey(t)
+	srv := fakedb.NewServer(testReplica)
+	cfg := testConfig(serve(t, srv), key)
+	putEntries(t, srv, "key", 10)
+	sign(t, cfg)
+
+	newState := func(t *testing.T) config.Config {
+		stateCfg := cfg
+		stateCfg.VerifierState = filepath.Join(t.TempDir(), "state.json")
+		checkStatus(t, validate(stateCfg), models.ValidationValid, "")
+		return stateCfg
+	}
+
+	t.Run("rollback", func(t *testing.T) {
+		stateCfg := newState(t)
+		cut := firstEntryAfter(t, srv, fakedb.FormatLseq(testReplica, 5))
+		rolledBack := copyDb(t, srv, func(item *proto.DBItems_DbItem) *proto.DBItems_DbItem {
+			if item.Lseq >= cut {
+				return nil
+			}
+			return item
+		})
+		stateCfg.Env.Db.ServerAddress = serve(t, rolledBack)
+		checkStatus(t, validate(stateCfg), models.ValidationInvalid, orchestrator.FailureRollback)
+	})
+
+	t.Run("fork", func(t *testing.T) {
+		stateCfg := newState(t)
+		cut := firstEntryAfter(t, srv, fakedb.FormatLseq(testReplica, 5))
+		forked := copyDb(t, srv, func(item *proto.DBItems_DbItem) *proto.DBItems_DbItem {
+			if item.Lseq >= cut {
+				return nil
+			}
+			return item
+		})
+		// The same key signs another history over the same lseqs
+		stateCfg.Env.Db.ServerAddress = serve(t, forked)
+		putEntries(t, forked, "forked", 20)
+		sign(t, stateCfg)
+		checkStatus(t, validate(stateCfg), models.ValidationInvalid, orchestrator.FailureFork)
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		stateCfg := newState(t)
+		putEntries(t, srv, "key", 5)
+		sign(t, stateCfg)
+		checkStatus(t, validate(stateCfg), models.ValidationValid, "")
+	})
+}
+
+// A restarted MMR signer continues from the peaks of its last record instead
+// of replaying the history, and the entries stay provable
+func TestMMRSigner(t *testing.T) {
+	srv := fakedb.NewServer(testReplica)
+	addr, counter := serveCounted(t, srv)
+	batchSize := uint32(10)
+	cfg := testConfig(addr, newKey(t))
+	cfg.HashCalculator = config.HashCalculatorMMR
+	cfg.Db.BatchSize = &batchSize
+	putEntries(t, srv, "key", 300)
+	sign(t, cfg)
+
+	// A replay reads the 600 entries and records again
+	putEntries(t, srv, "more", 5)
+	before := counter.count("GetReplicaEvents")
+	sign(t, cfg)
+	if reads := counter.count("GetReplicaEvents") - before; reads >= 60 {
+		t.Fatalf("restarted signer read %d pages, expected fewer than the signed history", reads)
+	}
+	checkStatus(t, validate(cfg), models.ValidationValid, "")
+
+	dbState, err := db.CreateDbState(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbState.CloseConnection()
+	cfg.Proof = config.Proof{
+		Lseq: firstEntryAfter(t, srv, fakedb.FormatLseq(testReplica, 37)),
+		Path: filepath.Join(t.TempDir(), "proof.json"),
+	}
+	if code := prove(context.Background(), dbState, cfg); code != report.ExitValid {
+		t.Fatalf("exit code of the proof is %d, expected %d", code, report.ExitValid)
+	}
+	if code := verifyProof(context.Background(), dbState, cfg); code != report.ExitValid {
+		t.Fatalf("exit code of the proof verification is %d, expected %d", code, report.ExitValid)
+	}
+}
+
+// A checkpoint record covers the entries since the previous one, so an edit
+// of any of them is found at the next checkpoint
+func TestCheckpointSigning(t *testing.T) {
+	srv := fakedb.NewServer(testReplica)
+	key := newKey(t)
+	cfg := testConfig(serve(t, srv), key)
+	cfg.Checkpoint.Every = 4
+	putEntries(t, srv, "key", 12)
+	sign(t, cfg)
+	checkStatus(t, validate(cfg), models.ValidationValid, "")
+
+	// Records are stored under the lseq of the entry they sign
+	entries := map[string]bool{}
+	records := 0
+	for _, item := range events(t, srv) {
+		if !strings.HasPrefix(item.Key, db.DefaultValidationPrefix) {
+			entries[item.Lseq] = true
+		} else if entries[strings.TrimPrefix(item.Key, db.DefaultValidationPrefix)] {
+			records++
+		}
+	}
+	if records != 12/cfg.Checkpoint.Every {
+		t.Fatalf("%d records for 12 entries signed every %d", records, cfg.Checkpoint.Every)
+	}
+
+	entry := firstEntryAfter(t, srv, fakedb.FormatLseq(testReplica, 2))
+	tampered := copyDb(t, srv, func(item *proto.DBItems_DbItem) *proto.DBItems_DbItem {
+		if item.Lseq == entry {
+			return &proto.DBItems_DbItem{Lseq: item.Lseq, Key: item.Key, Value: "tampered"}
+		}
+		return item
+	})
+	result := validate(testConfig(serve(t, tampered), key))
+	checkStatus(t, result, models.ValidationInvalid, orchestrator.FailureHashMismatch)
+	if result.LastValidLseq >= entry {
+		t.Fatalf("last valid lseq %s is not before the tampered lseq %s", result.LastValidLseq, entry)
+	}
+}
+
+// A range starts from the signed record at or before 'from' and stops at the
+// first one at or after 'to', edits outside it aren't looked at
+func TestValidationRange(t *testing.T) {
+	srv := fakedb.NewServer(testReplica)
+	key := newKey(t)
+	cfg := testConfig(serve(t, srv), key)
+	for i := 0; i < 3; i++ {
+		putEntries(t, srv, fmt.Sprintf("key%d-", i), 10)
+		sign(t, cfg)
+	}
+	from := firstEntryAfter(t, srv, fakedb.FormatLseq(testReplica, 5))
+	to := firstEntryAfter(t, srv, fakedb.FormatLseq(testReplica, 20))
+	edited := firstEntryAfter(t, srv, fakedb.FormatLseq(testReplica, 40))
+	tampered := copyDb(t, srv, func(item *proto.DBItems_DbItem) *proto.DBItems_DbItem {
+		if item.Lseq == edited {
+			return &proto.DBItems_DbItem{Lseq: item.Lseq, Key: item.Key, Value: "tampered"}
+		}
+		return item
+	})
+	cfg.Env.Db.ServerAddress = serve(t, tampered)
+
+	rangeCfg := cfg
+	rangeCfg.ValidationRange = config.ValidationRange{From: from, To: to}
+	result := validate(rangeCfg)
+	checkStatus(t, result, models.ValidationValid, "")
+	if result.AnchorLseq == "" || result.AnchorLseq > from {
+		t.Fatalf("anchor %q is not at or before %s", result.AnchorLseq, from)
+	}
+	if result.LastValidLseq < to || result.LastValidLseq >= edited {
+		t.Fatalf("last valid lseq %s is not between %s and %s", result.LastValidLseq, to, edited)
+	}
+
+	rangeCfg.ValidationRange = config.ValidationRange{From: to}
+	result = validate(rangeCfg)
+	checkStatus(t, result, models.ValidationInvalid, orchestrator.FailureHashMismatch)
+	if result.Failure.Lseq < to || result.LastValidLseq >= edited {
+		t.Fatalf("failure on %s after %s, the edit is on %s", result.Failure.Lseq, result.LastValidLseq, edited)
+	}
+}
+
+// Every replica of the list is validated with its own server and key, the
+// worst result decides the exit code
+func TestMultiReplicaValidation(t *testing.T) {
+	cfg := config.Config{RunMode: config.RunModeValidation}
+	cfg.Report = config.Report{Format: report.FormatJSON, Path: filepath.Join(t.TempDir(), "report.json")}
+	other := newKey(t)
+	for replicaId := int32(1); replicaId <= 3; replicaId++ {
+		srv := fakedb.NewServer(replicaId)
+		key := newKey(t)
+		replicaCfg := testConfig(serve(t, srv), key)
+		replicaCfg.Env.Db.ReplicaID = replicaId
+		putEntries(t, srv, "key", 5)
+		sign(t, replicaCfg)
+		// The third replica is signed by another key than the configured one
+		if replicaId == 3 {
+			key = other
+		}
+		cfg.Replicas = append(cfg.Replicas, config.Replica{
+			ReplicaID:     replicaId,
+			Address:       replicaCfg.Env.Db.ServerAddress,
+			PublicKeyFile: writePublicKey(t, key),
+		})
+	}
+
+	if code := validateReplicas(context.Background(), cfg); code != report.ExitInvalid {
+		t.Fatalf("exit code is %d, expected %d", code, report.ExitInvalid)
+	}
+	contents, err := os.ReadFile(cfg.Report.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cluster models.ClusterResult
+	if err := json.Unmarshal(contents, &cluster); err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Status != models.ValidationInvalid || len(cluster.Replicas) != 3 {
+		t.Fatalf("cluster result is %s with %d replicas", cluster.Status, len(cluster.Replicas))
+	}
+	for idx, replica := range cluster.Replicas {
+		if replica.ReplicaID != int32(idx+1) {
+			t.Fatalf("result %d is of replica %d", idx, replica.ReplicaID)
+		}
+		if replica.ReplicaID == 3 {
+			checkStatus(t, replica.ValidationResult, models.ValidationInvalid, orchestrator.FailureBadSignature)
+		} else {
+			checkStatus(t, replica.ValidationResult, models.ValidationValid, "")
+		}
+	}
+}
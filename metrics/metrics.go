@@ -0,0 +1,182 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"lsm-verification/models"
+)
+
+// Signer state of one replica. The methods do nothing on a nil replica.
+type Replica struct {
+	mu        sync.Mutex
+	replicaId int32
+	running   bool
+	batches   int64
+	errors    int64
+	restarts  int64
+	lastError string
+	coverage  *models.Coverage
+}
+
+// Replicas signed by the process, served in the Prometheus text format
+type Registry struct {
+	mu       sync.Mutex
+	replicas map[int32]*Replica
+}
+
+func NewRegistry() *Registry {
+	return &Registry{replicas: map[int32]*Replica{}}
+}
+
+func (r *Registry) Replica(replicaId int32) *Replica {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	replica, exists := r.replicas[replicaId]
+	if !exists {
+		replica = &Replica{replicaId: replicaId}
+		r.replicas[replicaId] = replica
+	}
+	return replica
+}
+
+func (r *Replica) SetRunning(running bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.running = running
+}
+
+func (r *Replica) AddBatch() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches++
+}
+
+func (r *Replica) AddError(err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors++
+	r.lastError = err.Error()
+}
+
+func (r *Replica) AddRestart() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.restarts++
+}
+
+func (r *Replica) SetCoverage(coverage models.Coverage) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.coverage = &coverage
+}
+
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	replicas := make([]*Replica, 0, len(r.replicas))
+	for _, replica := range r.replicas {
+		replicas = append(replicas, replica)
+	}
+	r.mu.Unlock()
+	sort.Slice(replicas, func(i, j int) bool { return replicas[i].replicaId < replicas[j].replicaId })
+
+	metrics := []struct {
+		name, kind, help string
+		value            func(r *Replica) (int64, bool)
+	}{
+		{"lsmv_signer_up", "gauge", "1 while the signer of the replica runs", func(r *Replica) (int64, bool) {
+			if r.running {
+				return 1, true
+			}
+			return 0, true
+		}},
+		{"lsmv_signed_batches_total", "counter", "Batches signed", func(r *Replica) (int64, bool) { return r.batches, true }},
+		{"lsmv_sign_errors_total", "counter", "Failed signing rounds", func(r *Replica) (int64, bool) { return r.errors, true }},
+		{"lsmv_signer_restarts_total", "counter", "Restarts of the signer after a failure", func(r *Replica) (int64, bool) { return r.restarts, true }},
+		{"lsmv_signed_entries", "gauge", "Entries covered by a signed record", func(r *Replica) (int64, bool) {
+			return coverageValue(r, func(c *models.Coverage) int64 { return c.SignedEntries })
+		}},
+		{"lsmv_unsigned_entries", "gauge", "Entries after the last signed lseq", func(r *Replica) (int64, bool) {
+			return coverageValue(r, func(c *models.Coverage) int64 { return c.UnsignedTail })
+		}},
+		{"lsmv_unsigned_seconds", "gauge", "Seconds the first unsigned entry has been unsigned", func(r *Replica) (int64, bool) {
+			return coverageValue(r, func(c *models.Coverage) int64 { return c.UnsignedSeconds })
+		}},
+		{"lsmv_head_distance", "gauge", "Events between the last signed lseq and the head", func(r *Replica) (int64, bool) {
+			return coverageValue(r, func(c *models.Coverage) int64 { return c.HeadDistance })
+		}},
+	}
+
+	var written int64
+	for _, metric := range metrics {
+		n, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		for _, replica := range replicas {
+			replica.mu.Lock()
+			value, exists := metric.value(replica)
+			replica.mu.Unlock()
+			if !exists {
+				continue
+			}
+			n, err := fmt.Fprintf(w, "%s{replica=\"%d\"} %d\n", metric.name, replica.replicaId, value)
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Coverage is unknown until the signer reads it
+func coverageValue(r *Replica, value func(c *models.Coverage) int64) (int64, bool) {
+	if r.coverage == nil {
+		return 0, false
+	}
+	return value(r.coverage), true
+}
+
+// Serves /metrics on the address until ctx is done
+func Serve(ctx context.Context, address string, registry *Registry) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if _, err := registry.WriteTo(w); err != nil {
+			log.Println("Failed to write the metrics: ", err)
+		}
+	})
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	log.Println("Serving metrics on: ", address)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
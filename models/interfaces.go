@@ -1,13 +1,185 @@
 package models
 
+// Encoding of the entries used to build the hash chain
+type HashScheme uint32
+
+const (
+	// prefix + lseq + key + value, ambiguous, kept to verify old chains
+	HashSchemeConcat HashScheme = 1
+	// length-prefixed fields with a domain-separation tag
+	HashSchemeLengthPrefixed HashScheme = 2
+	// length-prefixed, only the keys of the signers with a verified value of
+	// their kind are left out of the chain
+	HashSchemeSignerKeys HashScheme = 3
+)
+
+const CurrentHashScheme = HashSchemeSignerKeys
+
+// Keys in the validation prefix that only some schemes leave out of the chain
+type ReservedKey int
+
+const (
+	NotReserved ReservedKey = iota
+	// Value of a validation record that is not a verified record or service
+	// value of a signer, left out before HashSchemeSignerKeys
+	ReservedByValue
+)
+
 type DbItem struct {
-	Lseq  string
-	Key   string
-	Value string
+	Lseq     string
+	Key      string
+	Value    string
+	Reserved ReservedKey
+}
+
+func (i *DbItem) HashedBy(scheme HashScheme) bool {
+	switch i.Reserved {
+	case ReservedByValue:
+		return scheme >= HashSchemeSignerKeys
+	}
+	return true
 }
 
 type ValidateItem struct {
 	Lseq          *string
 	LseqItemValid string
 	Hash          string
+	Scheme        HashScheme
+	KeyID         string
+	// Signature of a verified record
+	Signature string
+	// Signed by a key revoked before this lseq
+	Revoked bool
+	// Unsigned running hash stored between two checkpoints
+	HashOnly bool
+	// Peaks of the MMR whose root is the hash, nil for other calculators
+	MMR *MMRState
+}
+
+// A signer continues the MMR from the peaks stored with its last record
+// instead of replaying the history. They are checked against the signed
+// root, so the signature doesn't cover them.
+type MMRState struct {
+	Size  uint64   `json:"size"`
+	Peaks []string `json:"peaks"`
+}
+
+// Lseqs signed by the key that have to be attested again
+type LseqRange struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	KeyID string `json:"key_id"`
+}
+
+// Entry left out of validation, Class is the failure class of the error
+type SkippedEntry struct {
+	Lseq string `json:"lseq"`
+	// Entries left unverified by a skipped hash mismatch, up to the record
+	// validation resumed from
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+	Class string `json:"class"`
+	Error string `json:"error"`
+}
+
+// Outcome of a validation run, incomplete when the database is consistent but
+// entries are skipped, signed by revoked keys or the run was interrupted
+type ValidationStatus string
+
+const (
+	ValidationValid      ValidationStatus = "valid"
+	ValidationInvalid    ValidationStatus = "invalid"
+	ValidationIncomplete ValidationStatus = "incomplete"
+	ValidationError      ValidationStatus = "error"
+	// Consistent, but an entry stays unsigned for longer than allowed
+	ValidationLagging ValidationStatus = "lagging"
+)
+
+// Entry the validation failed on. The hashes are empty when the record
+// couldn't be verified, the key and value when the entry is missing.
+type ValidationFailure struct {
+	Lseq         string `json:"lseq"`
+	Key          string `json:"key,omitempty"`
+	Value        string `json:"value,omitempty"`
+	ExpectedHash string `json:"expected_hash,omitempty"`
+	ComputedHash string `json:"computed_hash,omitempty"`
+	Category     string `json:"category"`
+	Error        string `json:"error"`
+}
+
+type ValidationResult struct {
+	Status ValidationStatus `json:"status"`
+	// Requested range, empty for the whole replica
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// Signed record the validation started from, empty for the genesis
+	AnchorLseq string `json:"anchor_lseq,omitempty"`
+	// Last lseq covered by a verified signed record
+	LastValidLseq string             `json:"last_valid_lseq,omitempty"`
+	Failure       *ValidationFailure `json:"failure,omitempty"`
+	Untrusted     []LseqRange        `json:"untrusted,omitempty"`
+	Skipped       []SkippedEntry     `json:"skipped,omitempty"`
+	Coverage      *Coverage          `json:"coverage,omitempty"`
+	// Error that stopped the validation before a result
+	Error string `json:"error,omitempty"`
+}
+
+type ReplicaResult struct {
+	ReplicaID int32 `json:"replica_id"`
+	ValidationResult
+}
+
+// Chain hash of a verified record of the signer
+type Attestation struct {
+	Lseq string `json:"lseq"`
+	Hash string `json:"hash"`
+}
+
+type SignerResult struct {
+	Signer string `json:"signer"`
+	ValidationResult
+	// Records of the signer at the last valid lseqs of the quorum signers
+	Attestations []Attestation `json:"attestations,omitempty"`
+}
+
+// History on which at least Quorum of the signers attest the same hash. A
+// signer failing or attesting another hash is outvoted and listed as
+// dissenting.
+type QuorumResult struct {
+	Status ValidationStatus `json:"status"`
+	Quorum int              `json:"quorum"`
+	// Last lseq with the same hash attested by at least Quorum signers
+	LastValidLseq string `json:"last_valid_lseq,omitempty"`
+	// Signers attesting the hash of the last valid lseq
+	Votes int `json:"votes,omitempty"`
+	// First failure after the last valid lseq, set when Quorum signers fail
+	Failure    *ValidationFailure `json:"failure,omitempty"`
+	Dissenting []string           `json:"dissenting,omitempty"`
+	Error      string             `json:"error,omitempty"`
+	Signers    []SignerResult     `json:"signers"`
+}
+
+// Results of the replicas validated in one run, the status is the worst of them
+type ClusterResult struct {
+	Status   ValidationStatus `json:"status"`
+	Replicas []ReplicaResult  `json:"replicas"`
+}
+
+// Entries signed or validated by a run against the unsigned tail of the replica
+type Coverage struct {
+	// Entries from the genesis, the signed ones and the unsigned tail
+	TotalEntries int64 `json:"total_entries"`
+	// Entries from the genesis to the last signed lseq, whether or not this
+	// run validated them
+	SignedEntries  int64  `json:"signed_entries"`
+	LastSignedLseq string `json:"last_signed_lseq,omitempty"`
+	// Entries after the last signed lseq
+	UnsignedTail      int64  `json:"unsigned_tail"`
+	FirstUnsignedLseq string `json:"first_unsigned_lseq,omitempty"`
+	// How long the first unsigned entry has been seen unsigned
+	UnsignedSeconds int64  `json:"unsigned_seconds,omitempty"`
+	HeadLseq        string `json:"head_lseq,omitempty"`
+	// Events between the last signed lseq and the head, including the
+	// validation records
+	HeadDistance int64 `json:"head_distance"`
 }
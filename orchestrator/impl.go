@@ -1,7 +1,11 @@
 package orchestrator
 
 import (
+	"context"
+	"errors"
 	"log"
+	"sort"
+	"time"
 
 	"lsm-verification/models"
 	"lsm-verification/db"
@@ -11,20 +15,47 @@ import (
 type orchestrator struct {
 	db db.DbState
 	calculator calculations.HashCalculator
+	untrusted []models.LseqRange
+	previousRevoked bool
+	policy *FailurePolicy
+	skipped []models.SkippedEntry
+
+	checkpoint *CheckpointPolicy
+	// calculated items since the last checkpoint
+	pending []models.ValidateItem
+	lastCheckpointAt time.Time
+
+	lastSigned string
+	// entries from the genesis to countedTo, counted once for the coverage
+	countedTo string
+	counted int64
+	// first unsigned entry and when it was seen first
+	firstUnsigned string
+	unsignedSince time.Time
 }
 
-func (o *orchestrator) SignNew() error {
-	lastValidated, err := o.db.GetLastValidated()
+// Not retried by the failure policy: the reads are retried by the database
+// client and the signer loop waits out an unavailable database
+func (o *orchestrator) SignNew(ctx context.Context) error {
+	if o.checkpoint != nil {
+		return o.signCheckpoints(ctx)
+	}
+
+	lastValidated, err := o.db.GetLastValidated(ctx)
 	if err != nil {
 		return err
 	}
 	log.Println("Got last validated")
+	if lastValidated != nil {
+		o.lastSigned = lastValidated.LseqItemValid
+		o.resume(lastValidated)
+	}
 
 	var batch []models.DbItem
 	if lastValidated != nil {
-		batch, err = o.db.ReadBatch(&lastValidated.LseqItemValid)
+		batch, err = o.readHashedBatch(ctx, &lastValidated.LseqItemValid)
 	} else {
-		batch, err = o.db.ReadBatch(nil)
+		batch, err = o.readHashedBatch(ctx, nil)
 	}
 	if err != nil {
 		return err
@@ -37,9 +68,9 @@ func (o *orchestrator) SignNew() error {
 
 	var calculatedBatch []models.ValidateItem
 	if lastValidated != nil {
-		calculatedBatch, err = o.calculator.CalculateBatch(batch, &lastValidated.Hash)
+		calculatedBatch, err = o.calculator.CalculateBatch(ctx, batch, &lastValidated.Hash, models.CurrentHashScheme)
 	} else {
-		calculatedBatch, err = o.calculator.CalculateBatch(batch, nil)
+		calculatedBatch, err = o.calculator.CalculateBatch(ctx, batch, nil, models.CurrentHashScheme)
 	}
 	if err != nil {
 		return err
@@ -49,68 +80,679 @@ func (o *orchestrator) SignNew() error {
 	}
 
 	log.Println("Putting validated batch")
-	return o.db.PutBatch(calculatedBatch)
+	if err := o.db.PutBatch(ctx, calculatedBatch); err != nil {
+		return err
+	}
+	o.lastSigned = calculatedBatch[len(calculatedBatch)-1].LseqItemValid
+	return nil
 }
 
 
-func (o *orchestrator) ValidateFromLseq(lseqStart *string, hashLast* string) (*string, *string, error) {
-	if (lseqStart == nil && hashLast != nil) || (lseqStart != nil && hashLast == nil) {
-		return nil, nil, ErrBadInput
+func (o *orchestrator) Reconcile(ctx context.Context) error {
+	orphans, err := o.db.ReadOrphans(ctx)
+	if err != nil {
+		return err
+	}
+	if len(orphans) == 0 {
+		log.Println("No orphaned validation records")
+		return nil
 	}
 
-	batch, err := o.db.ReadBatch(lseqStart)
+	lastValidated, err := o.db.GetLastValidated(ctx)
 	if err != nil {
-		return nil, nil, err
+		return err
+	}
+	var cursor, hash *string
+	if lastValidated != nil {
+		cursor, hash = &lastValidated.LseqItemValid, &lastValidated.Hash
+	}
+
+	// The chain is recalculated up to the last orphan, the signed records
+	// are adopted up to the first one that doesn't match
+	target := orphans[len(orphans)-1].LseqItemValid
+	var adopted *models.ValidateItem
+	var conflict error
+	next := 0
+	for conflict == nil && next < len(orphans) && (cursor == nil || *cursor < target) {
+		batch, err := o.db.ReadBatch(ctx, cursor)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		batchOrphans := []models.ValidateItem{}
+		for end := next; end < len(orphans) && orphans[end].LseqItemValid <= batch[len(batch)-1].Lseq; end++ {
+			batchOrphans = append(batchOrphans, orphans[end])
+		}
+		_, calculatedBatch, err := o.calculateWithSchemes(ctx, batch, hash, batchOrphans)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range calculatedBatch {
+			if next == len(orphans) || orphans[next].LseqItemValid != item.LseqItemValid {
+				continue
+			}
+			orphan := &orphans[next]
+			next++
+			if orphan.Hash != item.Hash {
+				log.Println("Orphaned record doesn't match the chain on lseq:", orphan.LseqItemValid)
+				conflict = ErrOrphanConflict
+				break
+			}
+			if orphan.Revoked {
+				log.Println("Orphaned record is signed by a revoked key, signing again from lseq:", orphan.LseqItemValid)
+				next = len(orphans)
+				break
+			}
+			if !orphan.HashOnly {
+				adopted = orphan
+			}
+		}
+		cursor = &batch[len(batch)-1].Lseq
+		if len(calculatedBatch) > 0 {
+			hash = &calculatedBatch[len(calculatedBatch)-1].Hash
+		}
+	}
+	if conflict == nil && next < len(orphans) {
+		log.Println("Orphaned record covers a missing entry on lseq:", orphans[next].LseqItemValid)
+		conflict = ErrOrphanConflict
+	}
+
+	if adopted != nil {
+		log.Println("Adopting orphaned records up to lseq:", adopted.LseqItemValid)
+		if err := o.db.PutLastValidated(ctx, adopted.LseqItemValid); err != nil {
+			return err
+		}
+		o.lastSigned = adopted.LseqItemValid
+	}
+	return conflict
+}
+
+func (o *orchestrator) signCheckpoints(ctx context.Context) error {
+	var startLseq, startHash *string
+	if len(o.pending) > 0 {
+		last := &o.pending[len(o.pending)-1]
+		startLseq, startHash = &last.LseqItemValid, &last.Hash
+	} else {
+		lastValidated, err := o.db.GetLastValidated(ctx)
+		if err != nil {
+			return err
+		}
+		log.Println("Got last validated")
+		if lastValidated != nil {
+			startLseq, startHash = &lastValidated.LseqItemValid, &lastValidated.Hash
+			o.lastSigned = lastValidated.LseqItemValid
+			o.resume(lastValidated)
+		}
+	}
+	if o.lastCheckpointAt.IsZero() {
+		o.lastCheckpointAt = time.Now()
+	}
+
+	batch, err := o.readHashedBatch(ctx, startLseq)
+	if err != nil {
+		return err
 	}
 	if len(batch) == 0 {
-		log.Println("Batch is empty, validation done")
-		return lseqStart, nil, ErrNoNewEntities
+		if len(o.pending) > 0 && o.checkpointDue() {
+			return o.putCheckpoint(ctx)
+		}
+		log.Println("Batch is empty")
+		return ErrNoNewEntities
 	}
 	log.Println("Got batch")
 
-	calculatedBatch, err := o.calculator.CalculateBatch(batch, hashLast)
+	calculatedBatch, err := o.calculator.CalculateBatch(ctx, batch, startHash, models.CurrentHashScheme)
 	if err != nil {
+		return err
+	}
+	if len(batch) != len(calculatedBatch) {
+		return ErrBatchLenMismatch
+	}
+
+	for _, item := range calculatedBatch {
+		o.pending = append(o.pending, item)
+		if o.checkpointDue() {
+			if err := o.putCheckpoint(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (o *orchestrator) checkpointDue() bool {
+	if o.checkpoint.Every > 0 && len(o.pending) >= o.checkpoint.Every {
+		return true
+	}
+	return o.checkpoint.Interval > 0 && time.Since(o.lastCheckpointAt) >= o.checkpoint.Interval
+}
+
+// On failure the pending items are dropped, the next call resumes
+// from the last checkpoint in the database
+func (o *orchestrator) putCheckpoint(ctx context.Context) error {
+	pending := o.pending
+	o.pending = nil
+
+	log.Println("Putting checkpoint")
+	if err := o.db.PutCheckpoint(ctx, pending, o.checkpoint.StoreHashes); err != nil {
+		return err
+	}
+	o.lastCheckpointAt = time.Now()
+	o.lastSigned = pending[len(pending)-1].LseqItemValid
+	return nil
+}
+
+// A retried attempt starts over, so the ranges and skips it found are dropped
+func (o *orchestrator) ValidateFromLseq(ctx context.Context, lseqStart *string, hashLast* string) (*string, *string, error) {
+	untrusted, previousRevoked := len(o.untrusted), o.previousRevoked
+	skipped := append([]models.SkippedEntry(nil), o.skipped...)
+	for attempt := 0; ; attempt++ {
+		lseq, hash, err := o.validateFromLseq(ctx, lseqStart, hashLast)
+		if err == nil || err == ErrNoNewEntities || !o.policy.retry(ctx, err, attempt) {
+			return lseq, hash, err
+		}
+		o.untrusted, o.previousRevoked, o.skipped = o.untrusted[:untrusted], previousRevoked, skipped
+	}
+}
+
+// The anchor is trusted on its own, as a record validation resumes from.
+// An anchor the previous validation ended on continues its untrusted range.
+func (o *orchestrator) AnchorAt(ctx context.Context, lseq string) (*string, *string, error) {
+	anchor, err := o.db.ReadAnchor(ctx, lseq)
+	if err != nil || anchor == nil {
 		return nil, nil, err
 	}
-	if (len(batch) != len(calculatedBatch)) {
-		return nil, nil, ErrBatchLenMismatch
+	log.Println("Validating from the signed lseq:", anchor.LseqItemValid)
+	o.lastSigned = anchor.LseqItemValid
+	o.prune(anchor.LseqItemValid)
+	last := len(o.untrusted) - 1
+	if !anchor.Revoked || last < 0 || o.untrusted[last].To != anchor.LseqItemValid {
+		o.previousRevoked = false
+		o.trackRevoked(anchor.LseqItemValid, anchor)
 	}
-	log.Println("Calculated batch")
+	return &anchor.LseqItemValid, &anchor.Hash, nil
+}
 
-	lseqs := []string{}
-	for _, item := range batch {
-		lseqs = append(lseqs, item.Lseq)
+// A record is never rewritten by the signer, so a different hash or signature
+// means the history before it was replaced
+func (o *orchestrator) VerifyAnchor(ctx context.Context, anchor *models.ValidateItem) error {
+	log.Println("Checking the previously verified lseq:", anchor.LseqItemValid)
+	record, err := o.db.ReadSignedRecord(ctx, anchor.LseqItemValid)
+	if err != nil {
+		return err
 	}
-	validBatch, err := o.db.ReadBatchValidated(lseqs)
+	if record == nil {
+		return newValidationError(nil, anchor, "", nil, ErrHistoryRolledBack)
+	}
+	if record.Hash != anchor.Hash || record.Signature != anchor.Signature {
+		return newValidationError(nil, anchor, record.Hash, nil, ErrHistoryForked)
+	}
+
+	exists, err := o.db.HasEntry(ctx, anchor.LseqItemValid)
 	if err != nil {
-		return nil, nil, err
+		return err
+	}
+	if !exists {
+		return newValidationError(nil, anchor, "", nil, ErrHistoryRolledBack)
+	}
+	return nil
+}
+
+func (o *orchestrator) SignedRecord(ctx context.Context, lseq string) (*models.ValidateItem, error) {
+	return o.db.ReadSignedRecord(ctx, lseq)
+}
+
+// The unsigned tail is read from the database, the time its first entry is
+// unsigned is measured from the first call that saw it
+func (o *orchestrator) Coverage(ctx context.Context) (models.Coverage, error) {
+	var lastSigned *string
+	if o.lastSigned != "" {
+		lastSigned = &o.lastSigned
+	}
+	firstUnsigned, tail, distance, err := o.db.ReadTail(ctx, lastSigned)
+	if err != nil {
+		return models.Coverage{}, err
+	}
+	head, err := o.db.ReadHead(ctx)
+	if err != nil {
+		return models.Coverage{}, err
+	}
+	if firstUnsigned != o.firstUnsigned {
+		o.firstUnsigned, o.unsignedSince = firstUnsigned, time.Now()
+	}
+	signed, err := o.countSigned(ctx)
+	if err != nil {
+		return models.Coverage{}, err
+	}
+
+	coverage := models.Coverage{
+		TotalEntries: signed + tail,
+		SignedEntries: signed,
+		LastSignedLseq: o.lastSigned,
+		UnsignedTail: tail,
+		FirstUnsignedLseq: firstUnsigned,
+		HeadLseq: head,
+		HeadDistance: distance,
+	}
+	if firstUnsigned != "" {
+		coverage.UnsignedSeconds = int64(time.Since(o.unsignedSince).Seconds())
+	}
+	return coverage, nil
+}
+
+// Entries from the genesis to the last signed lseq, only the entries signed
+// since the previous call are read
+func (o *orchestrator) countSigned(ctx context.Context) (int64, error) {
+	if o.lastSigned < o.countedTo {
+		o.countedTo, o.counted = "", 0
+	}
+	if o.lastSigned == o.countedTo {
+		return o.counted, nil
+	}
+	var from *string
+	if o.countedTo != "" {
+		from = &o.countedTo
+	}
+	count, err := o.db.CountEntries(ctx, from, o.lastSigned)
+	if err != nil {
+		return 0, err
+	}
+	o.countedTo, o.counted = o.lastSigned, o.counted + count
+	return o.counted, nil
+}
+
+func (o *orchestrator) validateFromLseq(ctx context.Context, lseqStart *string, hashLast* string) (*string, *string, error) {
+	if (lseqStart == nil && hashLast != nil) || (lseqStart != nil && hashLast == nil) {
+		return nil, nil, ErrBadInput
+	}
+
+	// With checkpoints most entries have no signed record,
+	// so batches are read until a signed record covers them
+	batch := []models.DbItem{}
+	validBatch := []models.ValidateItem{}
+	for cursor := lseqStart; !hasSigned(validBatch); {
+		nextBatch, err := o.db.ReadBatch(ctx, cursor)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(nextBatch) == 0 {
+			break
+		}
+		log.Println("Got batch")
+
+		lseqs := []string{}
+		for _, item := range nextBatch {
+			lseqs = append(lseqs, item.Lseq)
+		}
+		nextValidBatch, err := o.readValidated(ctx, lseqs)
+		if err != nil {
+			return nil, nil, recordFailure(nextBatch, lseqStart, err)
+		}
+		log.Println("Got validated batch")
+
+		batch = append(batch, nextBatch...)
+		validBatch = append(validBatch, nextValidBatch...)
+		cursor = &nextBatch[len(nextBatch)-1].Lseq
+	}
+	if len(batch) == 0 {
+		log.Println("Batch is empty, validation done")
+		return lseqStart, hashLast, ErrNoNewEntities
 	}
-	log.Println("Got validated batch")
 
-	if (len(calculatedBatch) > len(validBatch)) {
-		log.Println("Batches arent equal, validated to last valid")
-	} else if (len(calculatedBatch) < len(validBatch)) {
+	readTo := batch[len(batch)-1].Lseq
+	batch, calculatedBatch, err := o.calculateWithSchemes(ctx, batch, hashLast, validBatch)
+	if err != nil {
+		return nil, nil, err
+	}
+	if (len(batch) != len(calculatedBatch)) {
 		return nil, nil, ErrBatchLenMismatch
 	}
+	log.Println("Calculated batch")
+
+	calculatedIdx := make(map[string]int, len(calculatedBatch))
+	for idx, item := range calculatedBatch {
+		calculatedIdx[item.LseqItemValid] = idx
+	}
 
-	for idx, validItem := range validBatch {
-		if (validItem.Hash != calculatedBatch[idx].Hash) {
+	// Unsigned hashes are compared as well to find the diverged entry
+	segmentStart := ""
+	if len(batch) > 0 {
+		segmentStart = batch[0].Lseq
+	}
+	var lastSigned *models.ValidateItem
+	// entries of the batch up to lastSigned
+	covered := 0
+	lastValid := func() *string {
+		if lastSigned == nil {
+			return lseqStart
+		}
+		return &lastSigned.LseqItemValid
+	}
+	for idx := range validBatch {
+		validItem := &validBatch[idx]
+		itemIdx, exists := calculatedIdx[validItem.LseqItemValid]
+		if !exists {
+			// The record covers an entry that is not in the database
+			return &validItem.LseqItemValid, nil, newValidationError(nil, validItem, "", lastValid(), ErrBatchLenMismatch)
+		}
+		if (validItem.Hash != calculatedBatch[itemIdx].Hash) {
 			log.Println("Batch not valid on lseq:", validItem.LseqItemValid)
-			return &validItem.LseqItemValid, nil, ErrValidationFailed
+			if !o.policy.skips(ErrValidationFailed) {
+				return &validItem.LseqItemValid, nil, newValidationError(&batch[itemIdx], validItem, calculatedBatch[itemIdx].Hash, lastValid(), ErrValidationFailed)
+			}
+
+			lastLseq, lastHash := lseqStart, hashLast
+			if lastSigned != nil {
+				lastLseq, lastHash = &lastSigned.LseqItemValid, &lastSigned.Hash
+			}
+			lseq, hash, unverifiedTo, err := o.resumeFrom(ctx, validBatch[idx:], readTo, lastLseq, lastHash)
+			o.skip(validItem.LseqItemValid, ErrValidationFailed, segmentStart, unverifiedTo)
+			if err == nil || err == ErrNoNewEntities {
+				if lseq != nil {
+					o.lastSigned = *lseq
+				}
+			}
+			return lseq, hash, err
+		}
+		if validItem.HashOnly {
+			continue
+		}
+
+		o.trackRevoked(segmentStart, validItem)
+		lastSigned = validItem
+		covered = itemIdx + 1
+		if itemIdx+1 < len(batch) {
+			segmentStart = batch[itemIdx+1].Lseq
 		}
 	}
 
+	if lastSigned == nil {
+		log.Println("Only unsigned entries are left, validation done")
+		return lseqStart, hashLast, ErrNoNewEntities
+	}
+	if covered < len(batch) {
+		log.Printf("%d entries of the batch after lseq %s are not signed yet\n", len(batch)-covered, lastSigned.LseqItemValid)
+	}
 	log.Println("Batch is valid")
-	lastItem := &validBatch[len(validBatch)-1]
-	if (len(calculatedBatch) > len(validBatch)) {
-		return &lastItem.LseqItemValid, nil, nil
+	o.lastSigned = lastSigned.LseqItemValid
+	return &lastSigned.LseqItemValid, &lastSigned.Hash, nil
+}
+
+func newValidationError(item *models.DbItem, record *models.ValidateItem, computedHash string, lastValid *string, err error) *ValidationError {
+	failure := models.ValidationFailure{
+		Lseq: record.LseqItemValid,
+		ExpectedHash: record.Hash,
+		ComputedHash: computedHash,
+		Category: string(Classify(err)),
+		Error: err.Error(),
+	}
+	if item != nil {
+		failure.Key, failure.Value = item.Key, item.Value
+	}
+	return &ValidationError{Failure: failure, LastValid: lastValid, Err: err}
+}
+
+// The entry of a record that failed verification, other errors are returned as is
+func recordFailure(batch []models.DbItem, lastValid *string, err error) error {
+	var recordErr *db.RecordError
+	if !errors.As(err, &recordErr) {
+		return err
+	}
+	record := &models.ValidateItem{LseqItemValid: recordErr.Lseq}
+	for idx := range batch {
+		if batch[idx].Lseq == recordErr.Lseq {
+			return newValidationError(&batch[idx], record, "", lastValid, err)
+		}
+	}
+	return newValidationError(nil, record, "", lastValid, err)
+}
+
+// Records failing with a skipped class are left out,
+// their entries are covered by the next record
+func (o *orchestrator) readValidated(ctx context.Context, lseqs []string) ([]models.ValidateItem, error) {
+	result := []models.ValidateItem{}
+	for len(lseqs) > 0 {
+		items, err := o.db.ReadBatchValidated(ctx, lseqs)
+		result = append(result, items...)
+		if err == nil {
+			break
+		}
+
+		var recordErr *db.RecordError
+		if !errors.As(err, &recordErr) || !o.policy.skips(err) {
+			return nil, err
+		}
+		o.skip(recordErr.Lseq, err, "", "")
+		for idx, lseq := range lseqs {
+			if lseq == recordErr.Lseq {
+				lseqs = lseqs[idx+1:]
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// After a skipped mismatch the chain continues from the next signed record,
+// a signed hash is trusted on its own. Without one validation ends at lastLseq.
+// Also returns the last entry left unverified: the record or the last entry read.
+func (o *orchestrator) resumeFrom(ctx context.Context, records []models.ValidateItem, cursor string, lastLseq, lastHash *string) (*string, *string, string, error) {
+	for {
+		for idx := range records {
+			if records[idx].HashOnly {
+				continue
+			}
+			anchor := &records[idx]
+			log.Println("Resuming validation from the signed lseq:", anchor.LseqItemValid)
+			o.previousRevoked = false
+			o.trackRevoked(anchor.LseqItemValid, anchor)
+			return &anchor.LseqItemValid, &anchor.Hash, anchor.LseqItemValid, nil
+		}
+
+		batch, err := o.db.ReadBatch(ctx, &cursor)
+		if err != nil {
+			return nil, nil, cursor, err
+		}
+		if len(batch) == 0 {
+			log.Println("No signed record after the skipped entry, validation done")
+			return lastLseq, lastHash, cursor, ErrNoNewEntities
+		}
+
+		lseqs := []string{}
+		for _, item := range batch {
+			lseqs = append(lseqs, item.Lseq)
+		}
+		if records, err = o.readValidated(ctx, lseqs); err != nil {
+			return nil, nil, cursor, recordFailure(batch, lastLseq, err)
+		}
+		cursor = batch[len(batch)-1].Lseq
+	}
+}
+
+// A record read again after resuming is recorded once, the entries are kept in lseq order.
+// from and to are the entries left unverified by a skipped mismatch.
+func (o *orchestrator) skip(lseq string, err error, from, to string) {
+	idx := sort.Search(len(o.skipped), func(i int) bool { return o.skipped[i].Lseq >= lseq })
+	if idx < len(o.skipped) && o.skipped[idx].Lseq == lseq {
+		return
+	}
+	log.Printf("Skipping lseq %s: %v\n", lseq, err)
+	o.skipped = append(o.skipped, models.SkippedEntry{})
+	copy(o.skipped[idx+1:], o.skipped[idx:])
+	o.skipped[idx] = models.SkippedEntry{
+		Lseq:  lseq,
+		From:  from,
+		To:    to,
+		Class: string(Classify(err)),
+		Error: err.Error(),
 	}
-	return &lastItem.LseqItemValid, &lastItem.Hash, nil
 }
 
-func CreateOrchestrator(db db.DbState, calculator calculations.HashCalculator) Orchestrator {
+func (o *orchestrator) Skipped() []models.SkippedEntry {
+	return o.skipped
+}
+
+func hasSigned(items []models.ValidateItem) bool {
+	for _, item := range items {
+		if !item.HashOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// Ranges and skipped entries ending before the lseq are reported already
+func (o *orchestrator) prune(lseq string) {
+	untrusted := []models.LseqRange{}
+	for _, lseqRange := range o.untrusted {
+		if lseqRange.To >= lseq {
+			untrusted = append(untrusted, lseqRange)
+		}
+	}
+	skipped := []models.SkippedEntry{}
+	for _, entry := range o.skipped {
+		if entry.Lseq >= lseq || entry.To >= lseq {
+			skipped = append(skipped, entry)
+		}
+	}
+	o.untrusted, o.skipped = untrusted, skipped
+}
+
+func (o *orchestrator) UntrustedRanges() []models.LseqRange {
+	return o.untrusted
+}
+
+// A signed item covers the entries from the previous signed one, consecutive
+// entries covered by the same revoked key form one range
+func (o *orchestrator) trackRevoked(from string, item *models.ValidateItem) {
+	if !item.Revoked {
+		o.previousRevoked = false
+		return
+	}
+
+	last := len(o.untrusted) - 1
+	if o.previousRevoked && o.untrusted[last].KeyID == item.KeyID {
+		o.untrusted[last].To = item.LseqItemValid
+	} else {
+		o.untrusted = append(o.untrusted, models.LseqRange{
+			From:  from,
+			To:    item.LseqItemValid,
+			KeyID: item.KeyID,
+		})
+	}
+	o.previousRevoked = true
+}
+
+// Every entry is hashed with the scheme of its record or, if it has none,
+// of the next record. The unsigned tail uses the scheme of the last record.
+// Returns the entries hashed by their scheme and their calculated items.
+func (o *orchestrator) calculateWithSchemes(ctx context.Context, batch []models.DbItem, hashStart *string, validBatch []models.ValidateItem) ([]models.DbItem, []models.ValidateItem, error) {
+	schemes := make([]models.HashScheme, len(batch))
+	scheme := models.CurrentHashScheme
+	if len(validBatch) > 0 {
+		scheme = validBatch[len(validBatch)-1].Scheme
+	}
+	next := len(validBatch) - 1
+	for idx := len(batch) - 1; idx >= 0; idx-- {
+		if next >= 0 && validBatch[next].LseqItemValid == batch[idx].Lseq {
+			scheme = validBatch[next].Scheme
+			next--
+		}
+		schemes[idx] = scheme
+	}
+
+	hashed := make([]models.DbItem, 0, len(batch))
+	hashedSchemes := make([]models.HashScheme, 0, len(batch))
+	for idx := range batch {
+		if batch[idx].HashedBy(schemes[idx]) {
+			hashed = append(hashed, batch[idx])
+			hashedSchemes = append(hashedSchemes, schemes[idx])
+		}
+	}
+
+	result := make([]models.ValidateItem, 0, len(hashed))
+	for start := 0; start < len(hashed); {
+		end := start + 1
+		for end < len(hashed) && hashedSchemes[end] == hashedSchemes[start] {
+			end++
+		}
+
+		calculated, err := o.calculator.CalculateBatch(ctx, hashed[start:end], hashStart, hashedSchemes[start])
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(calculated) == 0 {
+			return nil, nil, ErrBatchLenMismatch
+		}
+		result = append(result, calculated...)
+		hashStart = &calculated[len(calculated)-1].Hash
+		start = end
+	}
+	return hashed, result, nil
+}
+
+// Entries in the chain of the scheme
+func hashedBy(batch []models.DbItem, scheme models.HashScheme) []models.DbItem {
+	result := make([]models.DbItem, 0, len(batch))
+	for idx := range batch {
+		if batch[idx].HashedBy(scheme) {
+			result = append(result, batch[idx])
+		}
+	}
+	return result
+}
+
+// The MMR keeps only the roots of its last batch, a record read back from the
+// database, e.g. after a failed write, is continued from its peaks
+func (o *orchestrator) resume(record *models.ValidateItem) {
+	if mmr, ok := o.calculator.(calculations.MMRCalculator); ok {
+		mmr.Resume(record)
+	}
+}
+
+// Entries of the current scheme from the lseq on. A batch of items left out
+// of the chain, e.g. the records of other signers, is paged past, so it
+// doesn't end the signing.
+func (o *orchestrator) readHashedBatch(ctx context.Context, startLseq *string) ([]models.DbItem, error) {
+	for {
+		batch, err := o.db.ReadBatch(ctx, startLseq)
+		if err != nil || len(batch) == 0 {
+			return batch, err
+		}
+		hashed := hashedBy(batch, models.CurrentHashScheme)
+		if len(hashed) > 0 {
+			return hashed, nil
+		}
+		log.Println("Batch has no entries to hash, reading the next one")
+		startLseq = &batch[len(batch)-1].Lseq
+	}
+}
+
+// A nil policy aborts on every error
+func CreateOrchestrator(db db.DbState, calculator calculations.HashCalculator, policy *FailurePolicy) Orchestrator {
+	if policy == nil {
+		policy, _ = NewFailurePolicy(nil)
+	}
+	return &orchestrator{
+		db: db,
+		calculator: calculator,
+		policy: policy,
+	}
+}
+
+// Signs a checkpoint every policy.Every entries or policy.Interval
+func CreateCheckpointOrchestrator(db db.DbState, calculator calculations.HashCalculator, policy CheckpointPolicy, failurePolicy *FailurePolicy) Orchestrator {
+	if failurePolicy == nil {
+		failurePolicy, _ = NewFailurePolicy(nil)
+	}
 	return &orchestrator{
 		db: db,
 		calculator: calculator,
+		checkpoint: &policy,
+		policy: failurePolicy,
 	}
 }
@@ -1,21 +1,85 @@
 package orchestrator
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"lsm-verification/models"
+)
 
 var (
 	ErrNoNewEntities = errors.New("No new entities found")
 	ErrValidationFailed = errors.New("Validation failed")
 	ErrBatchLenMismatch = errors.New("Batches length mismatch")
 	ErrBadInput = errors.New("Bad input")
+	ErrOrphanConflict = errors.New("Orphaned validation record conflicts with the chain")
+	ErrUnknownFailureClass = errors.New("Unknown failure class")
+	ErrUnknownFailureAction = errors.New("Unknown failure action, expected abort, retry or skip")
+	ErrSkipNotSupported = errors.New("Only malformed_record, bad_signature and hash_mismatch failures can be skipped")
+	ErrHistoryRolledBack = errors.New("Verified record is missing, the history is truncated or rolled back")
+	ErrHistoryForked = errors.New("Verified record is replaced, the history is forked")
+	ErrNoQuorum = errors.New("Fewer signers than the quorum attest the same hash on any lseq")
 )
 
+// Failure of a single entry, unwraps to ErrValidationFailed for a diverged
+// hash or to the error of its validation record
+type ValidationError struct {
+	Failure models.ValidationFailure
+	// Last lseq covered by a verified record before the failure, nil if none
+	LastValid *string
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("lseq %s (%s): %v", e.Failure.Lseq, e.Failure.Category, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// A checkpoint is signed when either limit is reached, a zero limit is unused
+type CheckpointPolicy struct {
+	Every int
+	Interval time.Duration
+	// Store the unsigned running hash of every entry between checkpoints
+	StoreHashes bool
+}
+
 type Orchestrator interface {
-	SignNew() error
+	SignNew(ctx context.Context) error
+
+	// Adopts the records an interrupted signer wrote after the last
+	// validated lseq if they match the chain, so they are not signed twice
+	Reconcile(ctx context.Context) error
 
 	/* 
 	 * Returning
 	 * last lseq validated or lseq which is failed validation
 	 * last hash if we have something to validate
+	 * *ValidationError when an entry fails validation
 	 */
-	ValidateFromLseq(lseqStart *string, hashLast* string) (*string, *string, error)
+	ValidateFromLseq(ctx context.Context, lseqStart *string, hashLast* string) (*string, *string, error)
+
+	// Lseq and hash of the verified signed record at or before the lseq,
+	// to start ValidateFromLseq with. Both are nil without such a record.
+	AnchorAt(ctx context.Context, lseq string) (*string, *string, error)
+
+	// Checks that the chain still passes through a record verified by an
+	// earlier run, *ValidationError if it is missing or replaced
+	VerifyAnchor(ctx context.Context, anchor *models.ValidateItem) error
+
+	// Verified signed record of the lseq, nil if it is missing
+	SignedRecord(ctx context.Context, lseq string) (*models.ValidateItem, error)
+
+	// Entries signed or validated so far and the unsigned entries after them
+	Coverage(ctx context.Context) (models.Coverage, error)
+
+	// Ranges of validated lseqs signed by revoked keys, they need re-attestation
+	UntrustedRanges() []models.LseqRange
+
+	// Entries left out of validation by the failure policy
+	Skipped() []models.SkippedEntry
 }
@@ -0,0 +1,154 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"log"
+	"time"
+
+	"lsm-verification/db"
+	"lsm-verification/signature"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type FailureClass string
+
+const (
+	FailureTransport        FailureClass = "transport"
+	FailureNotFound         FailureClass = "not_found"
+	FailureMalformedRecord  FailureClass = "malformed_record"
+	FailureBadSignature     FailureClass = "bad_signature"
+	FailureHashMismatch     FailureClass = "hash_mismatch"
+	FailureBatchLenMismatch FailureClass = "batch_length_mismatch"
+	// A record verified by an earlier run is missing
+	FailureRollback FailureClass = "rollback"
+	// A record verified by an earlier run is replaced
+	FailureFork  FailureClass = "fork"
+	FailureOther FailureClass = "other"
+)
+
+type FailureAction string
+
+const (
+	ActionAbort FailureAction = "abort"
+	// Repeats the failed step, aborts after the retries
+	ActionRetry FailureAction = "retry"
+	// Leaves the entry out and records it, only for the classes of a single entry
+	ActionSkip FailureAction = "skip"
+)
+
+const (
+	defaultRetries = 5
+	retryDelay     = time.Second
+	maxRetryDelay  = 30 * time.Second
+)
+
+type FailureRule struct {
+	Action  FailureAction
+	Retries int
+}
+
+// Every class aborts unless configured otherwise. Transport errors of reads
+// are already retried by the database client.
+type FailurePolicy struct {
+	rules map[FailureClass]FailureRule
+}
+
+func NewFailurePolicy(rules map[FailureClass]FailureRule) (*FailurePolicy, error) {
+	policy := &FailurePolicy{rules: map[FailureClass]FailureRule{}}
+
+	for class, rule := range rules {
+		switch class {
+		case FailureTransport, FailureNotFound, FailureBatchLenMismatch, FailureRollback, FailureFork, FailureOther:
+			if rule.Action == ActionSkip {
+				return nil, ErrSkipNotSupported
+			}
+		case FailureMalformedRecord, FailureBadSignature, FailureHashMismatch:
+		default:
+			return nil, ErrUnknownFailureClass
+		}
+
+		switch rule.Action {
+		case ActionAbort, ActionSkip:
+		case ActionRetry:
+			if rule.Retries <= 0 {
+				rule.Retries = defaultRetries
+			}
+		default:
+			return nil, ErrUnknownFailureAction
+		}
+		policy.rules[class] = rule
+	}
+	return policy, nil
+}
+
+func (p *FailurePolicy) Rule(class FailureClass) FailureRule {
+	if rule, exists := p.rules[class]; exists {
+		return rule
+	}
+	return FailureRule{Action: ActionAbort}
+}
+
+func Classify(err error) FailureClass {
+	switch {
+	case errors.Is(err, ErrHistoryRolledBack):
+		return FailureRollback
+	case errors.Is(err, ErrHistoryForked):
+		return FailureFork
+	case errors.Is(err, ErrValidationFailed):
+		return FailureHashMismatch
+	case errors.Is(err, ErrBatchLenMismatch):
+		return FailureBatchLenMismatch
+	case errors.Is(err, db.ErrLastValidatedIsMissing), status.Code(err) == codes.NotFound:
+		return FailureNotFound
+	case db.IsTransient(err):
+		return FailureTransport
+	case errors.Is(err, signature.ErrVerification),
+		errors.Is(err, rsa.ErrVerification),
+		errors.Is(err, signature.ErrUnknownKey),
+		errors.Is(err, signature.ErrKeyOutOfRange),
+		errors.Is(err, db.ErrAlgorithmMismatch):
+		return FailureBadSignature
+	case errors.Is(err, db.ErrIncorrectValidationValue),
+		errors.Is(err, db.ErrUnsupportedRecordVersion),
+		errors.Is(err, db.ErrUnsupportedHashAlgorithm),
+		errors.Is(err, db.ErrRecordMismatch):
+		return FailureMalformedRecord
+	}
+
+	var recordErr *db.RecordError
+	if errors.As(err, &recordErr) {
+		return FailureMalformedRecord
+	}
+	return FailureOther
+}
+
+func (p *FailurePolicy) skips(err error) bool {
+	return p.Rule(Classify(err)).Action == ActionSkip
+}
+
+// Waits before the next attempt, false if the error is not retried
+func (p *FailurePolicy) retry(ctx context.Context, err error, attempt int) bool {
+	rule := p.Rule(Classify(err))
+	if rule.Action != ActionRetry || attempt >= rule.Retries || ctx.Err() != nil {
+		return false
+	}
+
+	delay := retryDelay
+	for i := 0; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	log.Printf("Retrying in %s, attempt %d of %d: %v\n", delay, attempt+1, rule.Retries, err)
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
@@ -0,0 +1,140 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"lsm-verification/db"
+	"lsm-verification/signature"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewFailurePolicy(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules map[FailureClass]FailureRule
+		err   error
+	}{
+		{"empty", nil, nil},
+		{"skipped entry", map[FailureClass]FailureRule{FailureHashMismatch: {Action: ActionSkip}, FailureBadSignature: {Action: ActionSkip}}, nil},
+		{"retried read", map[FailureClass]FailureRule{FailureTransport: {Action: ActionRetry, Retries: 2}}, nil},
+		{"skipped transport", map[FailureClass]FailureRule{FailureTransport: {Action: ActionSkip}}, ErrSkipNotSupported},
+		{"skipped fork", map[FailureClass]FailureRule{FailureFork: {Action: ActionSkip}}, ErrSkipNotSupported},
+		{"unknown class", map[FailureClass]FailureRule{"typo": {Action: ActionAbort}}, ErrUnknownFailureClass},
+		{"unknown action", map[FailureClass]FailureRule{FailureOther: {Action: "ignore"}}, ErrUnknownFailureAction},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewFailurePolicy(test.rules); err != test.err {
+				t.Fatalf("error is %v, expected %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestFailureRule(t *testing.T) {
+	policy, err := NewFailurePolicy(map[FailureClass]FailureRule{
+		FailureTransport: {Action: ActionRetry},
+		FailureOther:     {Action: ActionRetry, Retries: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule := policy.Rule(FailureTransport); rule.Retries != defaultRetries {
+		t.Errorf("retry without a count has %d retries, expected %d", rule.Retries, defaultRetries)
+	}
+	if rule := policy.Rule(FailureOther); rule.Retries != 2 {
+		t.Errorf("retry has %d retries, expected 2", rule.Retries)
+	}
+	if rule := policy.Rule(FailureHashMismatch); rule.Action != ActionAbort {
+		t.Errorf("unconfigured class has the action %s", rule.Action)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err   error
+		class FailureClass
+	}{
+		{ErrHistoryRolledBack, FailureRollback},
+		{ErrHistoryForked, FailureFork},
+		{fmt.Errorf("lseq 1: %w", ErrValidationFailed), FailureHashMismatch},
+		{ErrBatchLenMismatch, FailureBatchLenMismatch},
+		{db.ErrLastValidatedIsMissing, FailureNotFound},
+		{status.Error(codes.NotFound, "missing"), FailureNotFound},
+		{status.Error(codes.Unavailable, "down"), FailureTransport},
+		{fmt.Errorf("reading: %w", status.Error(codes.DeadlineExceeded, "slow")), FailureTransport},
+		{&db.RecordError{Lseq: "1", Err: signature.ErrVerification}, FailureBadSignature},
+		{&db.RecordError{Lseq: "1", Err: rsa.ErrVerification}, FailureBadSignature},
+		{signature.ErrUnknownKey, FailureBadSignature},
+		{signature.ErrKeyOutOfRange, FailureBadSignature},
+		{db.ErrAlgorithmMismatch, FailureBadSignature},
+		{&db.RecordError{Lseq: "1", Err: db.ErrIncorrectValidationValue}, FailureMalformedRecord},
+		{db.ErrUnsupportedRecordVersion, FailureMalformedRecord},
+		{db.ErrUnsupportedHashAlgorithm, FailureMalformedRecord},
+		{db.ErrRecordMismatch, FailureMalformedRecord},
+		// Any other error of a record is malformed
+		{&db.RecordError{Lseq: "1", Err: errors.New("truncated")}, FailureMalformedRecord},
+		{status.Error(codes.InvalidArgument, "bad"), FailureOther},
+		{errors.New("other"), FailureOther},
+	}
+	for _, test := range tests {
+		if class := Classify(test.err); class != test.class {
+			t.Errorf("%v is classified as %s, expected %s", test.err, class, test.class)
+		}
+	}
+}
+
+func TestRetryCounting(t *testing.T) {
+	policy, err := NewFailurePolicy(map[FailureClass]FailureRule{
+		FailureTransport: {Action: ActionRetry, Retries: 2},
+		FailureOther:     {Action: ActionAbort},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := status.Error(codes.Unavailable, "down")
+
+	// Attempts left wait for the delay, the context ends the wait
+	for attempt := 0; attempt < 2; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		start := time.Now()
+		if policy.retry(ctx, transport, attempt) {
+			t.Fatalf("attempt %d is retried after the context ended", attempt)
+		}
+		if time.Since(start) < 20*time.Millisecond {
+			t.Fatalf("attempt %d didn't wait for the delay", attempt)
+		}
+		cancel()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	start := time.Now()
+	for name, retried := range map[string]bool{
+		"retries used up": policy.retry(ctx, transport, 2),
+		"aborted class":   policy.retry(ctx, errors.New("other"), 0),
+		"unconfigured":    policy.retry(ctx, ErrValidationFailed, 0),
+	} {
+		if retried {
+			t.Errorf("%s: error is retried", name)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= retryDelay {
+		t.Fatalf("errors not retried waited %s", elapsed)
+	}
+
+	start = time.Now()
+	if !policy.retry(ctx, transport, 0) {
+		t.Fatal("first attempt is not retried")
+	}
+	if elapsed := time.Since(start); elapsed < retryDelay {
+		t.Fatalf("retried after %s, expected %s", elapsed, retryDelay)
+	}
+}
@@ -0,0 +1,111 @@
+package orchestrator
+
+import (
+	"lsm-verification/models"
+)
+
+// Every verified record of a signer has the chain hash computed from the
+// entries, so signers that verified the same history attest the same hash on
+// the same lseq. The history is accepted up to the last lseq on which k
+// signers attest one hash. A signer failing or attesting another hash on it is
+// outvoted. A failure after it costs the signer its vote, the history is
+// invalid only when k signers fail after it. Signers that couldn't be
+// validated don't vote. Neither do signers with skipped entries or entries
+// signed by a revoked key: their chain passes over entries they don't vouch
+// for, but their failures still count.
+func Quorum(k int, signers []models.SignerResult) models.QuorumResult {
+	result := models.QuorumResult{
+		Quorum:  k,
+		Signers: signers,
+	}
+
+	votes := map[models.Attestation]int{}
+	for _, signer := range signers {
+		if !voting(signer) {
+			continue
+		}
+		for _, attestation := range signer.Attestations {
+			if attestation.Lseq <= signer.LastValidLseq {
+				votes[attestation]++
+			}
+		}
+	}
+	// An lseq with two hashes attested by k signers each is forked
+	agreed := map[string]models.Attestation{}
+	forked := map[string]bool{}
+	for attestation, count := range votes {
+		if count < k {
+			continue
+		}
+		if _, ok := agreed[attestation.Lseq]; ok {
+			forked[attestation.Lseq] = true
+		}
+		agreed[attestation.Lseq] = attestation
+	}
+	var quorum *models.Attestation
+	for lseq, attestation := range agreed {
+		if !forked[lseq] && (quorum == nil || lseq > quorum.Lseq) {
+			attestation := attestation
+			quorum = &attestation
+		}
+	}
+	if quorum != nil {
+		result.LastValidLseq = quorum.Lseq
+		result.Votes = votes[*quorum]
+	}
+
+	incomplete := false
+	var late []models.SignerResult
+	for _, signer := range signers {
+		if signer.Status == models.ValidationIncomplete {
+			incomplete = true
+		}
+		if signer.Failure != nil && (quorum == nil || signer.Failure.Lseq > quorum.Lseq) {
+			late = append(late, signer)
+			continue
+		}
+		if signer.Failure != nil || (quorum != nil && attestsOther(signer, *quorum)) {
+			result.Dissenting = append(result.Dissenting, signer.Signer)
+		}
+	}
+
+	switch {
+	case len(late) >= k:
+		result.Status = models.ValidationInvalid
+		for _, signer := range late {
+			if result.Failure == nil || signer.Failure.Lseq < result.Failure.Lseq {
+				result.Failure = signer.Failure
+			}
+		}
+		return result
+	case quorum == nil:
+		result.Status = models.ValidationError
+		result.Error = ErrNoQuorum.Error()
+		return result
+	}
+	for _, signer := range late {
+		result.Dissenting = append(result.Dissenting, signer.Signer)
+	}
+	if incomplete {
+		result.Status = models.ValidationIncomplete
+	} else {
+		result.Status = models.ValidationValid
+	}
+	return result
+}
+
+func voting(signer models.SignerResult) bool {
+	return signer.Status != models.ValidationError && len(signer.Untrusted) == 0 && len(signer.Skipped) == 0
+}
+
+func attestsOther(signer models.SignerResult, quorum models.Attestation) bool {
+	if !voting(signer) {
+		return false
+	}
+	for _, attestation := range signer.Attestations {
+		if attestation.Lseq == quorum.Lseq {
+			return attestation.Hash != quorum.Hash
+		}
+	}
+	return false
+}
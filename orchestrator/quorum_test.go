@@ -0,0 +1,223 @@
+package orchestrator
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"lsm-verification/models"
+)
+
+func lseq(seq int) string {
+	return fmt.Sprintf("%020d@1", seq)
+}
+
+// Records of the honest history on every lseq up to the last one
+func attested(last int) []models.Attestation {
+	attestations := make([]models.Attestation, 0, last)
+	for seq := 1; seq <= last; seq++ {
+		attestations = append(attestations, models.Attestation{Lseq: lseq(seq), Hash: fmt.Sprintf("hash %d", seq)})
+	}
+	return attestations
+}
+
+func valid(name string, last int) models.SignerResult {
+	return models.SignerResult{
+		Signer: name,
+		ValidationResult: models.ValidationResult{
+			Status:        models.ValidationValid,
+			LastValidLseq: lseq(last),
+		},
+		Attestations: attested(last),
+	}
+}
+
+func failed(name string, at int) models.SignerResult {
+	return models.SignerResult{
+		Signer: name,
+		ValidationResult: models.ValidationResult{
+			Status:        models.ValidationInvalid,
+			LastValidLseq: lseq(at - 1),
+			Failure: &models.ValidationFailure{
+				Lseq:     lseq(at),
+				Category: string(FailureHashMismatch),
+			},
+		},
+		Attestations: attested(at - 1),
+	}
+}
+
+// The signer verified another history from the lseq on
+func forked(result models.SignerResult, from int) models.SignerResult {
+	attestations := make([]models.Attestation, 0, len(result.Attestations))
+	for seq, attestation := range result.Attestations {
+		if seq+1 >= from {
+			attestation.Hash = fmt.Sprintf("forked %d", seq+1)
+		}
+		attestations = append(attestations, attestation)
+	}
+	result.Attestations = attestations
+	return result
+}
+
+func errored(name string) models.SignerResult {
+	return models.SignerResult{
+		Signer: name,
+		ValidationResult: models.ValidationResult{
+			Status: models.ValidationError,
+			Error:  "unavailable",
+		},
+	}
+}
+
+func revoked(result models.SignerResult, from, to int) models.SignerResult {
+	result.Status = models.ValidationIncomplete
+	result.Untrusted = []models.LseqRange{{From: lseq(from), To: lseq(to), KeyID: "leaked"}}
+	return result
+}
+
+func skipped(result models.SignerResult, at int) models.SignerResult {
+	result.Status = models.ValidationIncomplete
+	result.Skipped = []models.SkippedEntry{{Lseq: lseq(at), Class: string(FailureBadSignature)}}
+	return result
+}
+
+func TestQuorum(t *testing.T) {
+	tests := []struct {
+		name       string
+		k          int
+		signers    []models.SignerResult
+		status     models.ValidationStatus
+		lastValid  string
+		failure    string
+		dissenting []string
+	}{
+		{
+			name:      "all agree",
+			k:         2,
+			signers:   []models.SignerResult{valid("a", 10), valid("b", 10), valid("c", 10)},
+			status:    models.ValidationValid,
+			lastValid: lseq(10),
+		},
+		{
+			name:      "accepted up to the k-th signer",
+			k:         2,
+			signers:   []models.SignerResult{valid("a", 10), valid("b", 7), valid("c", 3)},
+			status:    models.ValidationValid,
+			lastValid: lseq(7),
+		},
+		{
+			name:       "outvoted failure",
+			k:          2,
+			signers:    []models.SignerResult{valid("a", 10), valid("b", 10), failed("c", 5)},
+			status:     models.ValidationValid,
+			lastValid:  lseq(10),
+			dissenting: []string{"c"},
+		},
+		{
+			// The entries from lseq 5 are replaced and a rogue signer signs
+			// the forged history, the honest ones fail on it
+			name:      "forged history signed by one key",
+			k:         2,
+			signers:   []models.SignerResult{failed("a", 5), failed("b", 5), valid("rogue", 10)},
+			status:    models.ValidationInvalid,
+			lastValid: lseq(4),
+			failure:   lseq(5),
+		},
+		{
+			// The forged history is signed with a leaked key revoked from
+			// lseq 5, the rogue vote would outvote the honest signer alone
+			name:      "forged history signed by a revoked key",
+			k:         1,
+			signers:   []models.SignerResult{failed("a", 5), revoked(valid("rogue", 10), 5, 10)},
+			status:    models.ValidationInvalid,
+			lastValid: lseq(4),
+			failure:   lseq(5),
+		},
+		{
+			name:    "revoked signer doesn't make the quorum",
+			k:       2,
+			signers: []models.SignerResult{valid("a", 10), revoked(valid("b", 10), 5, 10)},
+			status:  models.ValidationError,
+		},
+		{
+			name:      "skipped signer doesn't vote",
+			k:         2,
+			signers:   []models.SignerResult{valid("a", 10), valid("b", 6), skipped(valid("c", 10), 8)},
+			status:    models.ValidationIncomplete,
+			lastValid: lseq(6),
+		},
+		{
+			name:      "failure of a signer without a vote counts",
+			k:         1,
+			signers:   []models.SignerResult{valid("a", 4), skipped(failed("b", 6), 2)},
+			status:    models.ValidationInvalid,
+			lastValid: lseq(4),
+			failure:   lseq(6),
+		},
+		{
+			name:       "failure after the quorum costs one vote",
+			k:          2,
+			signers:    []models.SignerResult{valid("a", 10), valid("b", 10), failed("c", 12)},
+			status:     models.ValidationValid,
+			lastValid:  lseq(10),
+			dissenting: []string{"c"},
+		},
+		{
+			name:      "failures after the quorum of k signers",
+			k:         2,
+			signers:   []models.SignerResult{valid("a", 10), failed("b", 12), failed("c", 11)},
+			status:    models.ValidationInvalid,
+			lastValid: lseq(10),
+			failure:   lseq(11),
+		},
+		{
+			name:       "signer attesting another hash is outvoted",
+			k:          2,
+			signers:    []models.SignerResult{valid("a", 10), forked(valid("b", 10), 6), valid("c", 8)},
+			status:     models.ValidationValid,
+			lastValid:  lseq(8),
+			dissenting: []string{"b"},
+		},
+		{
+			name:    "signers attesting different hashes don't make the quorum",
+			k:       2,
+			signers: []models.SignerResult{valid("a", 10), forked(valid("b", 10), 1)},
+			status:  models.ValidationError,
+		},
+		{
+			name:      "lseq with two hashes of the quorum is not accepted",
+			k:         2,
+			signers:   []models.SignerResult{valid("a", 10), valid("b", 10), forked(valid("c", 10), 9), forked(valid("d", 10), 9)},
+			status:    models.ValidationValid,
+			lastValid: lseq(8),
+		},
+		{
+			name:    "errors don't count",
+			k:       2,
+			signers: []models.SignerResult{valid("a", 10), errored("b"), errored("c")},
+			status:  models.ValidationError,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := Quorum(test.k, test.signers)
+			if result.Status != test.status {
+				t.Errorf("status is %s, expected %s", result.Status, test.status)
+			}
+			if result.LastValidLseq != test.lastValid {
+				t.Errorf("last valid lseq is %q, expected %q", result.LastValidLseq, test.lastValid)
+			}
+			failure := ""
+			if result.Failure != nil {
+				failure = result.Failure.Lseq
+			}
+			if failure != test.failure {
+				t.Errorf("failure is on %q, expected %q", failure, test.failure)
+			}
+			if !reflect.DeepEqual(result.Dissenting, test.dissenting) {
+				t.Errorf("dissenting signers are %v, expected %v", result.Dissenting, test.dissenting)
+			}
+		})
+	}
+}
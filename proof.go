@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+
+	"lsm-verification/calculations"
+	"lsm-verification/config"
+	"lsm-verification/db"
+	"lsm-verification/models"
+	"lsm-verification/report"
+)
+
+// Inclusion proof of an entry in the MMR root of a signed record. It is
+// checked against the record and the entry alone, without the rest of the
+// replica.
+type proofFile struct {
+	ReplicaID int32  `json:"replica_id"`
+	Lseq      string `json:"lseq"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	// Signed record holding the root
+	RootLseq string                       `json:"root_lseq"`
+	Root     string                       `json:"root"`
+	Proof    *calculations.InclusionProof `json:"proof"`
+}
+
+// Replays the signed history from the genesis and proves the entry against
+// the last signed root. Returns the exit code.
+func prove(ctx context.Context, dbState db.DbState, cfg config.Config) int {
+	if cfg.HashCalculator != config.HashCalculatorMMR {
+		log.Fatalln("Proofs are only supported by the calculator: ", config.HashCalculatorMMR)
+	}
+	if cfg.Proof.Lseq == "" || cfg.Proof.Path == "" {
+		log.Fatalln("Proof needs an lseq and a path")
+	}
+	calculator := calculations.CreateMMRProver(cfg.Proof.Lseq)
+	orch := createOrchestrator(dbState, calculator, cfg)
+
+	result := validationResult(ctx, orch, config.ValidationRange{}, nil)
+	if result.Status != models.ValidationValid {
+		logValidationResult(result)
+		log.Println("Only a valid history can be proven")
+		return report.ExitCode(result.Status)
+	}
+	root, err := orch.SignedRecord(ctx, result.LastValidLseq)
+	if err != nil || root == nil {
+		log.Println("Failed to read the signed root: ", err)
+		return report.ExitError
+	}
+	entry, err := dbState.ReadEntry(ctx, cfg.Proof.Lseq)
+	if err != nil || entry == nil {
+		log.Println("Failed to read the entry: ", err)
+		return report.ExitError
+	}
+	proof, err := calculator.Prove(root.Hash)
+	if err != nil {
+		log.Println("Failed to prove the entry: ", err)
+		return report.ExitError
+	}
+
+	encoded, err := json.MarshalIndent(proofFile{
+		ReplicaID: cfg.Env.Db.ReplicaID,
+		Lseq:      entry.Lseq,
+		Key:       entry.Key,
+		Value:     entry.Value,
+		RootLseq:  root.LseqItemValid,
+		Root:      root.Hash,
+		Proof:     proof,
+	}, "", "  ")
+	if err != nil {
+		log.Println("Failed to encode the proof: ", err)
+		return report.ExitError
+	}
+	if err := os.WriteFile(cfg.Proof.Path, encoded, 0644); err != nil {
+		log.Println("Failed to write the proof: ", err)
+		return report.ExitError
+	}
+	log.Printf("Lseq %s is proven against the root signed on lseq %s\n", entry.Lseq, root.LseqItemValid)
+	return report.ExitValid
+}
+
+// Reads only the signed root record and the entry: the record has to be
+// verified by a trusted key and the entry has to be unchanged and covered
+// by its root. Returns the exit code.
+func verifyProof(ctx context.Context, dbState db.DbState, cfg config.Config) int {
+	contents, err := os.ReadFile(cfg.Proof.Path)
+	if err != nil {
+		log.Println("Failed to read the proof: ", err)
+		return report.ExitError
+	}
+	var stored proofFile
+	if err := json.Unmarshal(contents, &stored); err != nil || stored.Proof == nil {
+		log.Println("Proof is malformed: ", err)
+		return report.ExitError
+	}
+	if stored.ReplicaID != cfg.Env.Db.ReplicaID {
+		log.Println("Proof belongs to replica: ", stored.ReplicaID)
+		return report.ExitError
+	}
+
+	root, err := dbState.ReadSignedRecord(ctx, stored.RootLseq)
+	if err != nil {
+		log.Println("Failed to verify the signed root: ", err)
+		return report.ExitInvalid
+	}
+	switch {
+	case root == nil:
+		log.Println("Signed root is missing, the history is rolled back: ", stored.RootLseq)
+		return report.ExitInvalid
+	case root.Hash != stored.Root:
+		log.Println("Signed root is replaced, the history is forked: ", stored.RootLseq)
+		return report.ExitInvalid
+	case root.Revoked:
+		log.Println("Signed root is signed by the revoked key: ", root.KeyID)
+		return report.ExitInvalid
+	}
+
+	entry, err := dbState.ReadEntry(ctx, stored.Lseq)
+	if err != nil {
+		log.Println("Failed to read the entry: ", err)
+		return report.ExitError
+	}
+	if entry == nil || entry.Key != stored.Key || entry.Value != stored.Value {
+		log.Println("Entry is missing or changed: ", stored.Lseq)
+		return report.ExitInvalid
+	}
+	if err := calculations.VerifyInclusion(*entry, stored.Proof, root.Hash); err != nil {
+		log.Println("Entry is not covered by the signed root: ", err)
+		return report.ExitInvalid
+	}
+	log.Printf("Lseq %s is covered by the root signed on lseq %s\n", stored.Lseq, stored.RootLseq)
+	return report.ExitValid
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"lsm-verification/config"
+	"lsm-verification/db"
+	"lsm-verification/models"
+	"lsm-verification/orchestrator"
+	"lsm-verification/report"
+)
+
+// Validates the records of every signer of the quorum concurrently, each
+// against its own key. Returns the exit code of the quorum result.
+func validateQuorum(ctx context.Context, cfg config.Config) int {
+	signers := cfg.Quorum.Signers
+	if cfg.Quorum.K < 1 || cfg.Quorum.K > len(signers) {
+		log.Fatalf("Quorum has to be between 1 and %d signers\n", len(signers))
+	}
+	signerCfgs := make([]config.Config, 0, len(signers))
+	seen := map[string]bool{}
+	// Signers sharing the records would count twice
+	records := map[string]bool{}
+	for _, signer := range signers {
+		if signer.Name == "" || seen[signer.Name] {
+			log.Fatalln("Signer needs a unique name: ", signer.Name)
+		}
+		seen[signer.Name] = true
+		recordsId := signer.Namespace
+		if signer.Attestation != nil && signer.Attestation.ReplicaID != nil {
+			recordsId = fmt.Sprintf("%s@%d", signer.Namespace, *signer.Attestation.ReplicaID)
+		}
+		if records[recordsId] {
+			log.Fatalln("Signer needs its own namespace or attestation replica: ", signer.Name)
+		}
+		records[recordsId] = true
+		if signer.PublicKeyFile == "" && len(signer.Keyring) == 0 {
+			log.Fatalln("Signer has no key: ", signer.Name)
+		}
+		signerCfg, err := cfg.ForSigner(signer)
+		if err != nil {
+			log.Fatalf("Failed to load the config of signer %s: %v\n", signer.Name, err)
+		}
+		signerCfgs = append(signerCfgs, signerCfg)
+	}
+
+	results := make([]models.SignerResult, len(signers))
+	var wg sync.WaitGroup
+	for idx, signer := range signers {
+		wg.Add(1)
+		go func(idx int, signer config.Signer) {
+			defer wg.Done()
+			results[idx] = models.SignerResult{
+				Signer:           signer.Name,
+				ValidationResult: validateConcurrently(ctx, signerCfgs[idx], signerPrefix(signer.Name)),
+			}
+		}(idx, signer)
+	}
+	wg.Wait()
+
+	// Signers attest the hashes of their records on the lseqs validated by
+	// the others, the quorum compares them
+	lseqs := map[string]bool{}
+	for _, signer := range results {
+		if signer.Status != models.ValidationError && signer.LastValidLseq != "" {
+			lseqs[signer.LastValidLseq] = true
+		}
+	}
+	for idx, signer := range signers {
+		wg.Add(1)
+		go func(idx int, signer config.Signer) {
+			defer wg.Done()
+			results[idx].Attestations = readAttestations(ctx, signerCfgs[idx], results[idx].ValidationResult, lseqs, signerPrefix(signer.Name))
+		}(idx, signer)
+	}
+	wg.Wait()
+
+	result := orchestrator.Quorum(cfg.Quorum.K, results)
+	for _, signer := range results {
+		log.Println(signerPrefix(signer.Signer) + resultSummary(signer.ValidationResult))
+	}
+	if len(result.Dissenting) > 0 {
+		log.Println("Warning: signers outvoted by the quorum: ", strings.Join(result.Dissenting, ", "))
+	}
+	log.Printf("%d of %d signers required, %d attest the last valid lseq, status: %s\n", result.Quorum, len(results), result.Votes, quorumSummary(result))
+	if cfg.Report.Format != "" {
+		if err := report.WriteQuorumFile(cfg.Report.Path, cfg.Report.Format, result); err != nil {
+			log.Println("Failed to write the report: ", err)
+			return report.ExitError
+		}
+	}
+	log.Println("Task is done")
+	return report.ExitCode(result.Status)
+}
+
+// Hashes of the verified records of a signer on the lseqs it validated, an
+// lseq without a signed record gets no vote
+func readAttestations(ctx context.Context, cfg config.Config, result models.ValidationResult, lseqs map[string]bool, prefix string) []models.Attestation {
+	if result.Status == models.ValidationError {
+		return nil
+	}
+	dbState, err := db.CreateDbState(ctx, cfg)
+	if err != nil {
+		log.Println(prefix+"failed to read the attestations: ", err)
+		return nil
+	}
+	defer dbState.CloseConnection()
+
+	orch := createOrchestrator(dbState, createHashCalculator(cfg), cfg)
+	var attestations []models.Attestation
+	for lseq := range lseqs {
+		if lseq > result.LastValidLseq {
+			continue
+		}
+		record, err := orch.SignedRecord(ctx, lseq)
+		if err != nil {
+			log.Printf("%sno attestation on lseq %s: %v\n", prefix, lseq, err)
+			continue
+		}
+		if record != nil {
+			attestations = append(attestations, models.Attestation{Lseq: lseq, Hash: record.Hash})
+		}
+	}
+	sort.Slice(attestations, func(i, j int) bool {
+		return attestations[i].Lseq < attestations[j].Lseq
+	})
+	return attestations
+}
+
+func signerPrefix(name string) string {
+	return "Signer " + name + ": "
+}
+
+func quorumSummary(result models.QuorumResult) string {
+	return resultSummary(models.ValidationResult{
+		Status:        result.Status,
+		LastValidLseq: result.LastValidLseq,
+		Failure:       result.Failure,
+		Error:         result.Error,
+	})
+}
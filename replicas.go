@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"lsm-verification/config"
+	"lsm-verification/db"
+	"lsm-verification/metrics"
+	"lsm-verification/models"
+	"lsm-verification/orchestrator"
+	"lsm-verification/report"
+	"lsm-verification/verifier"
+)
+
+// Logs the progress of a validation running together with others
+type validationProgress struct {
+	orchestrator.Orchestrator
+	prefix string
+}
+
+func (p validationProgress) ValidateFromLseq(ctx context.Context, lseqStart *string, hashLast *string) (*string, *string, error) {
+	lseq, hash, err := p.Orchestrator.ValidateFromLseq(ctx, lseqStart, hashLast)
+	if err == nil && lseq != nil {
+		log.Println(p.prefix + "validated to lseq " + *lseq)
+	}
+	return lseq, hash, err
+}
+
+// Validates every replica of the list concurrently, a failing replica doesn't
+// stop the others. Returns the exit code of the combined status.
+func validateReplicas(ctx context.Context, cfg config.Config) int {
+	results := make([]models.ReplicaResult, len(cfg.Replicas))
+	var wg sync.WaitGroup
+	for idx, replica := range cfg.Replicas {
+		wg.Add(1)
+		go func(idx int, replica config.Replica) {
+			defer wg.Done()
+			results[idx] = validateReplica(ctx, cfg, replica)
+		}(idx, replica)
+	}
+	wg.Wait()
+
+	cluster := models.ClusterResult{
+		Status:   report.CombinedStatus(results),
+		Replicas: results,
+	}
+	for _, result := range results {
+		log.Printf("Replica %d: %s\n", result.ReplicaID, resultSummary(result.ValidationResult))
+	}
+	log.Printf("%d replicas validated, status: %s\n", len(results), cluster.Status)
+	if cfg.Report.Format != "" {
+		if err := report.WriteClusterFile(cfg.Report.Path, cfg.Report.Format, cluster); err != nil {
+			log.Println("Failed to write the report: ", err)
+			return report.ExitError
+		}
+	}
+	log.Println("Task is done")
+	return report.ExitCode(cluster.Status)
+}
+
+func validateReplica(ctx context.Context, cfg config.Config, replica config.Replica) models.ReplicaResult {
+	result := models.ReplicaResult{ReplicaID: replica.ReplicaID}
+	replicaCfg, err := cfg.ForReplica(replica)
+	if err != nil {
+		result.Status = models.ValidationError
+		result.Error = err.Error()
+		return result
+	}
+	result.ValidationResult = validateConcurrently(ctx, replicaCfg, replicaPrefix(replica.ReplicaID))
+	return result
+}
+
+// Validation of one of the configs validated in the same run, the errors are
+// returned in the result
+func validateConcurrently(ctx context.Context, cfg config.Config, prefix string) models.ValidationResult {
+	var result models.ValidationResult
+	dbState, err := db.CreateDbState(ctx, cfg)
+	if err != nil {
+		result.Status = models.ValidationError
+		result.Error = err.Error()
+		return result
+	}
+	defer dbState.CloseConnection()
+
+	var state *verifier.State
+	if cfg.VerifierState != "" {
+		state, err = verifier.Load(cfg.VerifierState, cfg.Env.Db.ReplicaID)
+		if err != nil {
+			result.Status = models.ValidationError
+			result.Error = err.Error()
+			return result
+		}
+	}
+	orch := validationProgress{
+		Orchestrator: createOrchestrator(dbState, createHashCalculator(cfg), cfg),
+		prefix:       prefix,
+	}
+	result = validationResult(ctx, orch, startRange(cfg, state), state)
+	addCoverage(ctx, orch, &result)
+	if cfg.VerifierState != "" {
+		next, err := nextVerifierState(cfg, orch, state, result)
+		if err == nil {
+			err = saveVerifierState(cfg, state, next)
+		}
+		if err != nil {
+			log.Println(prefix+"failed to save the verifier state: ", err)
+			result.Status = models.ValidationError
+			result.Error = err.Error()
+		}
+	}
+	return result
+}
+
+// Signs every replica of the list in its own goroutine until ctx is done. A
+// signer failing with an unavailable database is restarted, any other error
+// stops it without stopping the others.
+func signReplicas(ctx context.Context, cfg config.Config) int {
+	replicaCfgs := make([]config.Config, 0, len(cfg.Replicas))
+	seen := map[int32]bool{}
+	for _, replica := range cfg.Replicas {
+		if seen[replica.ReplicaID] {
+			log.Fatalln("Replica is listed twice: ", replica.ReplicaID)
+		}
+		seen[replica.ReplicaID] = true
+		replicaCfg, err := cfg.ForReplica(replica)
+		if err != nil {
+			log.Fatalf("Failed to load the config of replica %d: %v\n", replica.ReplicaID, err)
+		}
+		checkAttestation(replicaCfg)
+		if replicaCfg.Env.Keys.PrivateKey == "" {
+			log.Fatalln("No private key for replica: ", replica.ReplicaID)
+		}
+		replicaCfgs = append(replicaCfgs, replicaCfg)
+	}
+
+	registry := metrics.NewRegistry()
+	if cfg.Metrics.Address != "" {
+		go serveMetrics(ctx, cfg, registry)
+	}
+	var wg sync.WaitGroup
+	stopped := make([]bool, len(replicaCfgs))
+	for idx, replicaCfg := range replicaCfgs {
+		wg.Add(1)
+		go func(idx int, replicaCfg config.Config) {
+			defer wg.Done()
+			stopped[idx] = !runReplicaSigner(ctx, replicaCfg, registry.Replica(replicaCfg.Env.Db.ReplicaID))
+		}(idx, replicaCfg)
+	}
+	wg.Wait()
+	log.Println("Task is done")
+	for _, failed := range stopped {
+		if failed {
+			return report.ExitError
+		}
+	}
+	return report.ExitValid
+}
+
+// False if the signer is stopped by an error
+func runReplicaSigner(ctx context.Context, cfg config.Config, stats *metrics.Replica) bool {
+	restartDelay := defaultRestartDelay
+	if cfg.SignTimeout > 0 && time.Duration(cfg.SignTimeout)*time.Second > restartDelay {
+		restartDelay = time.Duration(cfg.SignTimeout) * time.Second
+	}
+	for {
+		err := signReplica(ctx, cfg, stats)
+		if err == nil || ctx.Err() != nil {
+			return true
+		}
+		stats.AddError(err)
+		if !db.IsTransient(err) {
+			// A broken history or key is not fixed by a restart
+			log.Printf("%sSigner is stopped: %v\n", replicaPrefix(cfg.Env.Db.ReplicaID), err)
+			return false
+		}
+		stats.AddRestart()
+		log.Printf("%sSigner failed, restarting in %s: %v\n", replicaPrefix(cfg.Env.Db.ReplicaID), restartDelay, err)
+		select {
+		case <-ctx.Done():
+			return true
+		case <-time.After(restartDelay):
+		}
+	}
+}
+
+func signReplica(ctx context.Context, cfg config.Config, stats *metrics.Replica) error {
+	dbState, err := db.CreateDbState(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer dbState.CloseConnection()
+
+	calculator := createHashCalculator(cfg)
+	orch := createOrchestrator(dbState, calculator, cfg)
+	if err := prepareSigner(ctx, dbState, orch, calculator); err != nil {
+		return err
+	}
+	log.Println(replicaPrefix(cfg.Env.Db.ReplicaID) + "Signing")
+	stats.SetRunning(true)
+	defer stats.SetRunning(false)
+	return signLoop(ctx, orch, cfg, stats)
+}
+
+func replicaPrefix(replicaId int32) string {
+	return fmt.Sprintf("Replica %d: ", replicaId)
+}
+
+func resultSummary(result models.ValidationResult) string {
+	switch {
+	case result.Failure != nil:
+		return fmt.Sprintf("%s, %s on lseq %s", result.Status, result.Failure.Category, result.Failure.Lseq)
+	case result.Error != "":
+		return fmt.Sprintf("%s, %s", result.Status, result.Error)
+	case result.LastValidLseq != "":
+		return fmt.Sprintf("%s to lseq %s", result.Status, result.LastValidLseq)
+	}
+	return string(result.Status)
+}
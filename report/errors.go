@@ -0,0 +1,5 @@
+package report
+
+import "errors"
+
+var ErrUnknownFormat = errors.New("unknown report format, expected json or junit")
@@ -0,0 +1,113 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"lsm-verification/models"
+)
+
+const suiteName = "lsm-verification"
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// The chain is one test case, every skipped entry and untrusted range is
+// a skipped test case
+func writeJUnit(w io.Writer, result models.ValidationResult) error {
+	return writeJUnitSuites(w, []junitSuite{newJUnitSuite(suiteName, result)})
+}
+
+func newJUnitSuite(name string, result models.ValidationResult) junitSuite {
+	chain := junitCase{Name: "chain", ClassName: name}
+	suite := junitSuite{Name: name}
+	switch {
+	case result.Failure != nil:
+		failure := result.Failure
+		chain.Failure = &junitMessage{
+			Message: fmt.Sprintf("not valid on lseq %s", failure.Lseq),
+			Type:    failure.Category,
+			Text: fmt.Sprintf("lseq: %s\nkey: %s\nvalue: %s\nexpected hash: %s\ncomputed hash: %s\nerror: %s\n",
+				failure.Lseq, failure.Key, failure.Value, failure.ExpectedHash, failure.ComputedHash, failure.Error),
+		}
+		suite.Failures++
+	case result.Status == models.ValidationLagging:
+		chain.Failure = &junitMessage{
+			Message: fmt.Sprintf("lseq %s is unsigned for %ds", result.Coverage.FirstUnsignedLseq, result.Coverage.UnsignedSeconds),
+			Type:    "signer_lag",
+		}
+		suite.Failures++
+	case result.Status == models.ValidationError:
+		chain.Error = &junitMessage{Message: result.Error}
+		suite.Errors++
+	case result.Status == models.ValidationIncomplete && result.Error != "":
+		chain.Skipped = &junitMessage{Message: result.Error}
+		suite.Skipped++
+	}
+	suite.Cases = append(suite.Cases, chain)
+
+	for _, entry := range result.Skipped {
+		caseName := "lseq " + entry.Lseq
+		if entry.From != "" {
+			caseName = "lseqs " + entry.From + " to " + entry.To
+		}
+		suite.Cases = append(suite.Cases, junitCase{
+			Name:      caseName,
+			ClassName: name + ".skipped",
+			Skipped:   &junitMessage{Message: entry.Error, Type: entry.Class},
+		})
+		suite.Skipped++
+	}
+	for _, lseqRange := range result.Untrusted {
+		suite.Cases = append(suite.Cases, junitCase{
+			Name:      fmt.Sprintf("lseqs %s to %s", lseqRange.From, lseqRange.To),
+			ClassName: name + ".untrusted",
+			Skipped: &junitMessage{
+				Message: fmt.Sprintf("signed by the revoked key %s, needs re-attestation", lseqRange.KeyID),
+				Type:    "revoked_key",
+			},
+		})
+		suite.Skipped++
+	}
+	suite.Tests = len(suite.Cases)
+	return suite
+}
+
+func writeJUnitSuites(w io.Writer, suites []junitSuite) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(junitSuites{Suites: suites}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
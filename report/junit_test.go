@@ -0,0 +1,156 @@
+package report
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"lsm-verification/models"
+)
+
+func parseJUnit(t *testing.T, out *bytes.Buffer) []junitSuite {
+	if !strings.HasPrefix(out.String(), xml.Header) {
+		t.Fatalf("report has no XML header: %q", out.String())
+	}
+	var suites junitSuites
+	if err := xml.Unmarshal(out.Bytes(), &suites); err != nil {
+		t.Fatal(err)
+	}
+	return suites.Suites
+}
+
+func checkCounts(t *testing.T, suite junitSuite, tests, failures, errors, skipped int) {
+	if suite.Tests != tests || suite.Failures != failures || suite.Errors != errors || suite.Skipped != skipped {
+		t.Fatalf("suite %s has %d tests, %d failures, %d errors, %d skipped, expected %d, %d, %d, %d",
+			suite.Name, suite.Tests, suite.Failures, suite.Errors, suite.Skipped, tests, failures, errors, skipped)
+	}
+	if len(suite.Cases) != tests {
+		t.Fatalf("suite %s has %d cases for %d tests", suite.Name, len(suite.Cases), tests)
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	tests := []struct {
+		name                             string
+		result                           models.ValidationResult
+		tests, failures, errors, skipped int
+	}{
+		{"valid", models.ValidationResult{Status: models.ValidationValid}, 1, 0, 0, 0},
+		{"invalid", models.ValidationResult{
+			Status:  models.ValidationInvalid,
+			Failure: &models.ValidationFailure{Lseq: "5", Category: "hash_mismatch"},
+		}, 1, 1, 0, 0},
+		{"error", models.ValidationResult{Status: models.ValidationError, Error: "down"}, 1, 0, 1, 0},
+		{"interrupted", models.ValidationResult{Status: models.ValidationIncomplete, Error: "context canceled"}, 1, 0, 0, 1},
+		{"lagging", models.ValidationResult{
+			Status:   models.ValidationLagging,
+			Coverage: &models.Coverage{FirstUnsignedLseq: "7", UnsignedSeconds: 60},
+		}, 1, 1, 0, 0},
+		{"skipped and untrusted", models.ValidationResult{
+			Status:    models.ValidationIncomplete,
+			Skipped:   []models.SkippedEntry{{Lseq: "2", Class: "bad_signature"}, {Lseq: "4", From: "3", To: "6", Class: "hash_mismatch"}},
+			Untrusted: []models.LseqRange{{From: "8", To: "9", KeyID: "old"}},
+		}, 4, 0, 0, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Write(&out, FormatJUnit, test.result); err != nil {
+				t.Fatal(err)
+			}
+			suites := parseJUnit(t, &out)
+			if len(suites) != 1 || suites[0].Name != suiteName {
+				t.Fatalf("got suites %+v", suites)
+			}
+			checkCounts(t, suites[0], test.tests, test.failures, test.errors, test.skipped)
+		})
+	}
+}
+
+func TestJUnitFailureDetails(t *testing.T) {
+	var out bytes.Buffer
+	err := Write(&out, FormatJUnit, models.ValidationResult{
+		Status: models.ValidationInvalid,
+		Failure: &models.ValidationFailure{
+			Lseq:         "5",
+			Key:          "key",
+			ExpectedHash: "expected",
+			ComputedHash: "computed",
+			Category:     "hash_mismatch",
+		},
+		Skipped: []models.SkippedEntry{{Lseq: "4", From: "3", To: "6", Class: "hash_mismatch", Error: "Validation failed"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := parseJUnit(t, &out)[0].Cases
+	failure := cases[0].Failure
+	if failure == nil || failure.Type != "hash_mismatch" || !strings.Contains(failure.Message, "lseq 5") {
+		t.Fatalf("chain failure is %+v", failure)
+	}
+	for _, detail := range []string{"key: key", "expected hash: expected", "computed hash: computed"} {
+		if !strings.Contains(failure.Text, detail) {
+			t.Errorf("failure text %q has no %q", failure.Text, detail)
+		}
+	}
+	// A skipped mismatch names the range it leaves unverified
+	if cases[1].Name != "lseqs 3 to 6" || cases[1].Skipped == nil || cases[1].Skipped.Type != "hash_mismatch" {
+		t.Fatalf("skipped case is %+v", cases[1])
+	}
+}
+
+func TestWriteClusterJUnit(t *testing.T) {
+	var out bytes.Buffer
+	err := WriteCluster(&out, FormatJUnit, models.ClusterResult{
+		Status: models.ValidationError,
+		Replicas: []models.ReplicaResult{
+			{ReplicaID: 1, ValidationResult: models.ValidationResult{Status: models.ValidationValid}},
+			{ReplicaID: 2, ValidationResult: models.ValidationResult{Status: models.ValidationError, Error: "down"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	suites := parseJUnit(t, &out)
+	if len(suites) != 2 || suites[0].Name != suiteName+".replica-1" || suites[1].Name != suiteName+".replica-2" {
+		t.Fatalf("got suites %+v", suites)
+	}
+	checkCounts(t, suites[0], 1, 0, 0, 0)
+	checkCounts(t, suites[1], 1, 0, 1, 0)
+}
+
+func TestWriteQuorumJUnit(t *testing.T) {
+	var out bytes.Buffer
+	err := WriteQuorum(&out, FormatJUnit, models.QuorumResult{
+		Status:        models.ValidationValid,
+		Quorum:        2,
+		LastValidLseq: "9",
+		Votes:         2,
+		Dissenting:    []string{"c"},
+		Signers: []models.SignerResult{
+			{Signer: "a", ValidationResult: models.ValidationResult{Status: models.ValidationValid}},
+			{Signer: "b", ValidationResult: models.ValidationResult{Status: models.ValidationValid}},
+			{Signer: "c", ValidationResult: models.ValidationResult{
+				Status:  models.ValidationInvalid,
+				Failure: &models.ValidationFailure{Lseq: "4", Category: "hash_mismatch"},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	suites := parseJUnit(t, &out)
+	if len(suites) != 4 {
+		t.Fatalf("got %d suites, expected the quorum and 3 signers", len(suites))
+	}
+	// The outvoted signer is skipped in the quorum and fails in its own suite
+	checkCounts(t, suites[0], 2, 0, 0, 1)
+	if suites[0].Cases[1].Name != "signer c" {
+		t.Fatalf("dissenting case is %+v", suites[0].Cases[1])
+	}
+	if suites[3].Name != suiteName+".signer-c" {
+		t.Fatalf("signer suite is %s", suites[3].Name)
+	}
+	checkCounts(t, suites[3], 1, 1, 0, 0)
+}
@@ -0,0 +1,169 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"lsm-verification/models"
+)
+
+const (
+	FormatJSON  = "json"
+	FormatJUnit = "junit"
+)
+
+// Process exit codes of a validation run, log.Fatal exits with ExitError as well
+const (
+	ExitValid      = 0
+	ExitError      = 1
+	ExitInvalid    = 2
+	ExitIncomplete = 3
+)
+
+func ExitCode(status models.ValidationStatus) int {
+	switch status {
+	case models.ValidationValid:
+		return ExitValid
+	case models.ValidationInvalid:
+		return ExitInvalid
+	case models.ValidationIncomplete, models.ValidationLagging:
+		return ExitIncomplete
+	}
+	return ExitError
+}
+
+// Invalid history outweighs an error, an error outweighs an incomplete result
+func CombinedStatus(results []models.ReplicaResult) models.ValidationStatus {
+	status := models.ValidationValid
+	for _, result := range results {
+		status = WorseStatus(status, result.Status)
+	}
+	return status
+}
+
+func WorseStatus(a, b models.ValidationStatus) models.ValidationStatus {
+	if statusRank(b) > statusRank(a) {
+		return b
+	}
+	return a
+}
+
+func statusRank(status models.ValidationStatus) int {
+	switch status {
+	case models.ValidationValid:
+		return 0
+	case models.ValidationLagging:
+		return 1
+	case models.ValidationIncomplete:
+		return 2
+	case models.ValidationInvalid:
+		return 4
+	}
+	return 3
+}
+
+func CheckFormat(format string) error {
+	if format != FormatJSON && format != FormatJUnit {
+		return ErrUnknownFormat
+	}
+	return nil
+}
+
+func Write(w io.Writer, format string, result models.ValidationResult) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case FormatJUnit:
+		return writeJUnit(w, result)
+	}
+	return ErrUnknownFormat
+}
+
+// One junit suite per replica
+func WriteCluster(w io.Writer, format string, result models.ClusterResult) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case FormatJUnit:
+		suites := make([]junitSuite, 0, len(result.Replicas))
+		for _, replica := range result.Replicas {
+			suites = append(suites, newJUnitSuite(fmt.Sprintf("%s.replica-%d", suiteName, replica.ReplicaID), replica.ValidationResult))
+		}
+		return writeJUnitSuites(w, suites)
+	}
+	return ErrUnknownFormat
+}
+
+// The quorum suite lists the dissenting signers, followed by one junit suite
+// per signer
+func WriteQuorum(w io.Writer, format string, result models.QuorumResult) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case FormatJUnit:
+		quorum := newJUnitSuite(suiteName+".quorum", models.ValidationResult{
+			Status:        result.Status,
+			LastValidLseq: result.LastValidLseq,
+			Failure:       result.Failure,
+			Error:         result.Error,
+		})
+		for _, signer := range result.Dissenting {
+			quorum.Cases = append(quorum.Cases, junitCase{
+				Name:      "signer " + signer,
+				ClassName: quorum.Name + ".dissenting",
+				Skipped:   &junitMessage{Message: "outvoted by the quorum", Type: "dissent"},
+			})
+			quorum.Skipped++
+		}
+		quorum.Tests = len(quorum.Cases)
+
+		suites := []junitSuite{quorum}
+		for _, signer := range result.Signers {
+			suites = append(suites, newJUnitSuite(suiteName+".signer-"+signer.Signer, signer.ValidationResult))
+		}
+		return writeJUnitSuites(w, suites)
+	}
+	return ErrUnknownFormat
+}
+
+// Writes to stdout when the path is empty, the logs go to stderr
+func WriteFile(path, format string, result models.ValidationResult) error {
+	return writeFile(path, func(w io.Writer) error {
+		return Write(w, format, result)
+	})
+}
+
+func WriteClusterFile(path, format string, result models.ClusterResult) error {
+	return writeFile(path, func(w io.Writer) error {
+		return WriteCluster(w, format, result)
+	})
+}
+
+func WriteQuorumFile(path, format string, result models.QuorumResult) error {
+	return writeFile(path, func(w io.Writer) error {
+		return WriteQuorum(w, format, result)
+	})
+}
+
+func writeFile(path string, write func(w io.Writer) error) error {
+	if path == "" {
+		return write(os.Stdout)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,133 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"lsm-verification/models"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := map[models.ValidationStatus]int{
+		models.ValidationValid:      ExitValid,
+		models.ValidationInvalid:    ExitInvalid,
+		models.ValidationIncomplete: ExitIncomplete,
+		models.ValidationLagging:    ExitIncomplete,
+		models.ValidationError:      ExitError,
+		"":                          ExitError,
+	}
+	for status, code := range tests {
+		if exitCode := ExitCode(status); exitCode != code {
+			t.Errorf("status %q exits with %d, expected %d", status, exitCode, code)
+		}
+	}
+}
+
+func TestCombinedStatus(t *testing.T) {
+	replicas := func(statuses ...models.ValidationStatus) []models.ReplicaResult {
+		results := []models.ReplicaResult{}
+		for idx, status := range statuses {
+			results = append(results, models.ReplicaResult{ReplicaID: int32(idx), ValidationResult: models.ValidationResult{Status: status}})
+		}
+		return results
+	}
+	tests := []struct {
+		name    string
+		results []models.ReplicaResult
+		status  models.ValidationStatus
+	}{
+		{"no replicas", nil, models.ValidationValid},
+		{"all valid", replicas(models.ValidationValid, models.ValidationValid), models.ValidationValid},
+		{"lagging", replicas(models.ValidationValid, models.ValidationLagging), models.ValidationLagging},
+		{"incomplete over lagging", replicas(models.ValidationIncomplete, models.ValidationLagging), models.ValidationIncomplete},
+		{"error over incomplete", replicas(models.ValidationIncomplete, models.ValidationError, models.ValidationValid), models.ValidationError},
+		{"invalid over error", replicas(models.ValidationError, models.ValidationInvalid, models.ValidationIncomplete), models.ValidationInvalid},
+	}
+	for _, test := range tests {
+		if status := CombinedStatus(test.results); status != test.status {
+			t.Errorf("%s: status is %s, expected %s", test.name, status, test.status)
+		}
+	}
+}
+
+func TestCheckFormat(t *testing.T) {
+	for format, err := range map[string]error{FormatJSON: nil, FormatJUnit: nil, "xml": ErrUnknownFormat, "": ErrUnknownFormat} {
+		if checked := CheckFormat(format); checked != err {
+			t.Errorf("format %q: error is %v, expected %v", format, checked, err)
+		}
+	}
+	var out bytes.Buffer
+	if err := Write(&out, "xml", models.ValidationResult{}); err != ErrUnknownFormat || out.Len() != 0 {
+		t.Fatalf("unknown format wrote %q with %v", out.String(), err)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	result := models.ValidationResult{
+		Status:        models.ValidationInvalid,
+		LastValidLseq: "00000000000000000004@1",
+		Failure: &models.ValidationFailure{
+			Lseq:         "00000000000000000005@1",
+			Key:          "key",
+			ExpectedHash: "expected",
+			ComputedHash: "computed",
+			Category:     "hash_mismatch",
+			Error:        "Validation failed",
+		},
+		Skipped: []models.SkippedEntry{{Lseq: "00000000000000000002@1", Class: "bad_signature", Error: "signature verification failed"}},
+	}
+	var out bytes.Buffer
+	if err := Write(&out, FormatJSON, result); err != nil {
+		t.Fatal(err)
+	}
+	var decoded models.ValidationResult
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, result) {
+		t.Fatalf("decoded %+v, expected %+v", decoded, result)
+	}
+	// Empty fields are left out
+	var fields map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"untrusted", "coverage", "error", "anchor_lseq"} {
+		if _, exists := fields[field]; exists {
+			t.Errorf("empty field %s is written", field)
+		}
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	result := models.ClusterResult{
+		Status: models.ValidationError,
+		Replicas: []models.ReplicaResult{
+			{ReplicaID: 1, ValidationResult: models.ValidationResult{Status: models.ValidationValid}},
+			{ReplicaID: 2, ValidationResult: models.ValidationResult{Status: models.ValidationError, Error: "down"}},
+		},
+	}
+	if err := WriteClusterFile(path, FormatJSON, result); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded models.ClusterResult
+	if err := json.Unmarshal(contents, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, result) {
+		t.Fatalf("decoded %+v, expected %+v", decoded, result)
+	}
+
+	if err := WriteFile(filepath.Join(t.TempDir(), "missing", "report.json"), FormatJSON, models.ValidationResult{}); err == nil {
+		t.Fatal("report is written to a missing directory")
+	}
+}
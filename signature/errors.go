@@ -6,3 +6,8 @@ var ErrNoPEMBlock = errors.New("no PEM block found")
 var ErrWrongKeyType = errors.New("wrong RSA key type")
 var ErrUnableToParseKey = errors.New("unable to parse the key")
 var ErrNoPassphrase = errors.New("no passphrase provided for an encrypted RSA private key")
+var ErrUnsupportedKeyType = errors.New("unsupported key type, expected RSA, Ed25519 or ECDSA")
+var ErrUnsupportedCurve = errors.New("unsupported ECDSA curve, expected P-256")
+var ErrVerification = errors.New("signature verification failed")
+var ErrUnknownKey = errors.New("key is not in the keyring")
+var ErrKeyOutOfRange = errors.New("key is not valid for the lseq")
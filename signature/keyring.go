@@ -0,0 +1,129 @@
+package signature
+
+import "time"
+
+// Lseqs are compared as strings, a key covers the lseqs in (ValidAfter, ValidUntil],
+// an empty bound means the range is open on that side.
+type KeyringEntry struct {
+	Verifier   Verifier
+	ValidAfter string
+	ValidUntil string
+	// Signatures of lseqs starting from RevokedFrom or made since RevokedAt
+	// are not trusted even if they are valid
+	RevokedFrom string
+	RevokedAt   time.Time
+}
+
+func (e *KeyringEntry) Covers(lseq string) bool {
+	if e.ValidAfter != "" && lseq <= e.ValidAfter {
+		return false
+	}
+	if e.ValidUntil != "" && lseq > e.ValidUntil {
+		return false
+	}
+	return true
+}
+
+// A signature without a signing time can't be placed before RevokedAt. The
+// signing time is set by the signer and is not trusted: a leaked key can
+// backdate it, only RevokedFrom cuts off what the key signs after the leak.
+func (e *KeyringEntry) Revoked(lseq string, signedAt *time.Time) bool {
+	if e.RevokedFrom != "" && lseq >= e.RevokedFrom {
+		return true
+	}
+	if !e.RevokedAt.IsZero() && (signedAt == nil || !signedAt.Before(e.RevokedAt)) {
+		return true
+	}
+	return false
+}
+
+// Set of trusted public keys, each valid for a range of lseqs
+type Keyring struct {
+	entries map[string]*KeyringEntry
+	order   []string
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{
+		entries: make(map[string]*KeyringEntry),
+	}
+}
+
+func (k *Keyring) Add(verifier Verifier, validAfter, validUntil string) {
+	if _, exists := k.entries[verifier.KeyID()]; !exists {
+		k.order = append(k.order, verifier.KeyID())
+	}
+	k.entries[verifier.KeyID()] = &KeyringEntry{
+		Verifier:   verifier,
+		ValidAfter: validAfter,
+		ValidUntil: validUntil,
+	}
+}
+
+func (k *Keyring) Len() int {
+	return len(k.order)
+}
+
+func (k *Keyring) Entry(keyID string) (*KeyringEntry, bool) {
+	entry, exists := k.entries[keyID]
+	return entry, exists
+}
+
+func (k *Keyring) Find(keyID, lseq string) (*KeyringEntry, error) {
+	entry, exists := k.entries[keyID]
+	if !exists {
+		return nil, ErrUnknownKey
+	}
+	if !entry.Covers(lseq) {
+		return nil, ErrKeyOutOfRange
+	}
+	return entry, nil
+}
+
+// Keys valid for the lseq, used for records that don't carry a key ID
+func (k *Keyring) Covering(lseq string) []*KeyringEntry {
+	result := []*KeyringEntry{}
+	for _, keyID := range k.order {
+		if entry := k.entries[keyID]; entry.Covers(lseq) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// Keeps the earliest cut-off if the key is revoked several times
+func (k *Keyring) Revoke(keyID, from string, at time.Time) error {
+	entry, exists := k.entries[keyID]
+	if !exists {
+		return ErrUnknownKey
+	}
+
+	if from != "" && (entry.RevokedFrom == "" || from < entry.RevokedFrom) {
+		entry.RevokedFrom = from
+	}
+	if !at.IsZero() && (entry.RevokedAt.IsZero() || at.Before(entry.RevokedAt)) {
+		entry.RevokedAt = at
+	}
+	return nil
+}
+
+// The old key stops being valid after the lseq and the new one takes over
+func (k *Keyring) Rotate(oldKeyID string, newVerifier Verifier, after string) error {
+	oldEntry, exists := k.entries[oldKeyID]
+	if !exists {
+		return ErrUnknownKey
+	}
+	if !oldEntry.Covers(after) && after != oldEntry.ValidAfter {
+		return ErrKeyOutOfRange
+	}
+
+	oldEntry.ValidUntil = after
+	// A revocation of the new key applied before the rotation is kept
+	newEntry, exists := k.entries[newVerifier.KeyID()]
+	k.Add(newVerifier, after, "")
+	if exists {
+		k.entries[newVerifier.KeyID()].RevokedFrom = newEntry.RevokedFrom
+		k.entries[newVerifier.KeyID()].RevokedAt = newEntry.RevokedAt
+	}
+	return nil
+}
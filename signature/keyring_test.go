@@ -0,0 +1,111 @@
+package signature
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+func newVerifier(t *testing.T) Verifier {
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewVerifier(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return verifier
+}
+
+func TestKeyringRanges(t *testing.T) {
+	keyring := NewKeyring()
+	old, current := newVerifier(t), newVerifier(t)
+	keyring.Add(old, "", "")
+
+	if err := keyring.Rotate(old.KeyID(), current, "10"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		keyID string
+		lseq  string
+		err   error
+	}{
+		{keyID: old.KeyID(), lseq: "05"},
+		{keyID: old.KeyID(), lseq: "10"},
+		{keyID: old.KeyID(), lseq: "11", err: ErrKeyOutOfRange},
+		{keyID: current.KeyID(), lseq: "10", err: ErrKeyOutOfRange},
+		{keyID: current.KeyID(), lseq: "11"},
+		{keyID: "unknown", lseq: "11", err: ErrUnknownKey},
+	}
+	for _, test := range tests {
+		if _, err := keyring.Find(test.keyID, test.lseq); err != test.err {
+			t.Fatalf("key %s at %s: error is %v, expected %v", test.keyID, test.lseq, err, test.err)
+		}
+	}
+	if covering := keyring.Covering("10"); len(covering) != 1 || covering[0].Verifier != old {
+		t.Fatalf("lseq 10 is covered by %d keys", len(covering))
+	}
+
+	// A rotation can't move the old key past its range
+	if err := keyring.Rotate(old.KeyID(), newVerifier(t), "12"); err != ErrKeyOutOfRange {
+		t.Fatalf("rotation after the range: %v", err)
+	}
+	if err := keyring.Rotate("unknown", newVerifier(t), "12"); err != ErrUnknownKey {
+		t.Fatalf("rotation of an unknown key: %v", err)
+	}
+}
+
+func TestKeyringRevocation(t *testing.T) {
+	keyring := NewKeyring()
+	old, current := newVerifier(t), newVerifier(t)
+	keyring.Add(old, "", "")
+	keyring.Add(current, "", "")
+
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := keyring.Revoke(current.KeyID(), "20", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	// The earliest cut-off is kept
+	if err := keyring.Revoke(current.KeyID(), "30", at); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Revoke(current.KeyID(), "", at.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	entry, _ := keyring.Entry(current.KeyID())
+	if entry.RevokedFrom != "20" || !entry.RevokedAt.Equal(at) {
+		t.Fatalf("revoked from %s at %s", entry.RevokedFrom, entry.RevokedAt)
+	}
+
+	before, after := at.Add(-time.Minute), at
+	tests := []struct {
+		lseq     string
+		signedAt *time.Time
+		revoked  bool
+	}{
+		{lseq: "19", signedAt: &before},
+		{lseq: "20", signedAt: &before, revoked: true},
+		{lseq: "19", signedAt: &after, revoked: true},
+		// An unknown signing time can't be placed before the revocation
+		{lseq: "19", revoked: true},
+	}
+	for _, test := range tests {
+		if revoked := entry.Revoked(test.lseq, test.signedAt); revoked != test.revoked {
+			t.Fatalf("lseq %s signed at %v: revoked is %v", test.lseq, test.signedAt, revoked)
+		}
+	}
+
+	// A revocation of the new key survives the rotation to it
+	if err := keyring.Rotate(old.KeyID(), current, "10"); err != nil {
+		t.Fatal(err)
+	}
+	entry, _ = keyring.Entry(current.KeyID())
+	if entry.RevokedFrom != "20" || entry.ValidAfter != "10" {
+		t.Fatalf("rotated key is valid after %s, revoked from %s", entry.ValidAfter, entry.RevokedFrom)
+	}
+	if err := keyring.Revoke("unknown", "20", time.Time{}); err != ErrUnknownKey {
+		t.Fatalf("revocation of an unknown key: %v", err)
+	}
+}
@@ -1,15 +1,14 @@
 package signature
 
 import (
-	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 )
 
 // Written with help from https://stackoverflow.com/questions/44230634/how-to-read-an-rsa-key-from-file
 
-func loadPEM(rsaKeyContents string) (*pem.Block, error) {
-	decoded, _ := pem.Decode([]byte(rsaKeyContents))
+func loadPEM(keyContents string) (*pem.Block, error) {
+	decoded, _ := pem.Decode([]byte(keyContents))
 	if decoded == nil {
 		return nil, ErrNoPEMBlock
 	}
@@ -17,44 +16,52 @@ func loadPEM(rsaKeyContents string) (*pem.Block, error) {
 	return decoded, nil
 }
 
-func LoadPrivateKey(rsaKeyContents string) (*rsa.PrivateKey, error) {
-	pemBlock, err := loadPEM(rsaKeyContents)
+// Detects RSA, Ed25519 and ECDSA P-256 keys in PKCS#1, SEC 1 and PKCS#8 PEM blocks
+func LoadSigner(keyContents string) (Signer, error) {
+	pemBlock, err := loadPEM(keyContents)
 	if err != nil {
 		return nil, err
 	}
 
 	var parsedKey interface{}
-	parsedKey, err = x509.ParsePKCS1PrivateKey(pemBlock.Bytes)
+	parsedKey, err = x509.ParsePKCS8PrivateKey(pemBlock.Bytes)
 	if err != nil {
-		parsedKey, err = x509.ParsePKCS8PrivateKey(pemBlock.Bytes)
-		if err != nil {
-			return nil, err
-		}
+		parsedKey, err = x509.ParsePKCS1PrivateKey(pemBlock.Bytes)
 	}
-
-	privKey, ok := parsedKey.(*rsa.PrivateKey)
-	if !ok {
+	if err != nil {
+		parsedKey, err = x509.ParseECPrivateKey(pemBlock.Bytes)
+	}
+	if err != nil {
 		return nil, ErrUnableToParseKey
 	}
 
-	return privKey, nil
+	return NewSigner(parsedKey)
 }
 
-func LoadPublicKey(rsaKeyContents string) (*rsa.PublicKey, error) {
-	pemBlock, err := loadPEM(rsaKeyContents)
+// Detects RSA, Ed25519 and ECDSA P-256 keys in PKIX and PKCS#1 PEM blocks
+func LoadVerifier(keyContents string) (Verifier, error) {
+	pemBlock, err := loadPEM(keyContents)
 	if err != nil {
 		return nil, err
 	}
 
-	parsedKey, err := x509.ParsePKIXPublicKey(pemBlock.Bytes)
+	var parsedKey interface{}
+	parsedKey, err = x509.ParsePKIXPublicKey(pemBlock.Bytes)
+	if err != nil {
+		parsedKey, err = x509.ParsePKCS1PublicKey(pemBlock.Bytes)
+	}
 	if err != nil {
 		return nil, ErrUnableToParseKey
 	}
 
-	pubKey, ok := parsedKey.(*rsa.PublicKey)
-	if !ok {
-		return nil, ErrUnableToParseKey
+	return NewVerifier(parsedKey)
+}
+
+func EncodePublicKey(verifier Verifier) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(verifier.PublicKey())
+	if err != nil {
+		return "", err
 	}
 
-	return pubKey, nil
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
 }
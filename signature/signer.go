@@ -0,0 +1,242 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+)
+
+const (
+	AlgorithmRSAPSS    = "rsa-pss-sha256"
+	AlgorithmEd25519   = "ed25519"
+	AlgorithmECDSAP256 = "ecdsa-p256-sha256"
+)
+
+const keyIDLength = 16
+
+// Signs hex-encoded SHA-256 digests, the signature is hex-encoded as well
+type Signer interface {
+	Algorithm() string
+	KeyID() string
+	Sign(dataHex string) (string, error)
+	Verifier() Verifier
+}
+
+type Verifier interface {
+	Algorithm() string
+	KeyID() string
+	PublicKey() crypto.PublicKey
+	Verify(signatureHex, dataHex string) error
+}
+
+// Key ID is the hex-encoded prefix of the SHA-256 of the PKIX public key
+func Fingerprint(publicKey crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(der)
+	return hex.EncodeToString(hash[:keyIDLength]), nil
+}
+
+type keyID string
+
+func (k keyID) KeyID() string {
+	return string(k)
+}
+
+// The signer owns the verifier of its public key
+type signerVerifier struct {
+	verifier Verifier
+}
+
+func (s signerVerifier) KeyID() string {
+	return s.verifier.KeyID()
+}
+
+func (s signerVerifier) Verifier() Verifier {
+	return s.verifier
+}
+
+func NewSigner(privateKey interface{}) (Signer, error) {
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKeyType
+	}
+	verifier, err := NewVerifier(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return &rsaSigner{signerVerifier: signerVerifier{verifier}, key: key}, nil
+	case ed25519.PrivateKey:
+		return &ed25519Signer{signerVerifier: signerVerifier{verifier}, key: key}, nil
+	case *ecdsa.PrivateKey:
+		return &ecdsaSigner{signerVerifier: signerVerifier{verifier}, key: key}, nil
+	}
+	return nil, ErrUnsupportedKeyType
+}
+
+func NewVerifier(publicKey interface{}) (Verifier, error) {
+	id, err := Fingerprint(publicKey)
+	if err != nil {
+		return nil, ErrUnsupportedKeyType
+	}
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return &rsaVerifier{keyID: keyID(id), key: key}, nil
+	case ed25519.PublicKey:
+		return &ed25519Verifier{keyID: keyID(id), key: key}, nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return nil, ErrUnsupportedCurve
+		}
+		return &ecdsaVerifier{keyID: keyID(id), key: key}, nil
+	}
+	return nil, ErrUnsupportedKeyType
+}
+
+func decodeSignatureAndData(signatureHex, dataHex string) ([]byte, []byte, error) {
+	dataBytes, err := hex.DecodeString(dataHex)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signatureBytes, err := hex.DecodeString(signatureHex)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return signatureBytes, dataBytes, nil
+}
+
+type rsaSigner struct {
+	signerVerifier
+	key *rsa.PrivateKey
+}
+
+func (s *rsaSigner) Algorithm() string {
+	return AlgorithmRSAPSS
+}
+
+func (s *rsaSigner) Sign(dataHex string) (string, error) {
+	return Sign(dataHex, s.key)
+}
+
+type rsaVerifier struct {
+	keyID
+	key *rsa.PublicKey
+}
+
+func (v *rsaVerifier) PublicKey() crypto.PublicKey {
+	return v.key
+}
+
+func (v *rsaVerifier) Algorithm() string {
+	return AlgorithmRSAPSS
+}
+
+func (v *rsaVerifier) Verify(signatureHex, dataHex string) error {
+	return VerifySignature(signatureHex, dataHex, v.key)
+}
+
+type ed25519Signer struct {
+	signerVerifier
+	key ed25519.PrivateKey
+}
+
+func (s *ed25519Signer) Algorithm() string {
+	return AlgorithmEd25519
+}
+
+func (s *ed25519Signer) Sign(dataHex string) (string, error) {
+	dataBytes, err := hex.DecodeString(dataHex)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(ed25519.Sign(s.key, dataBytes)), nil
+}
+
+type ed25519Verifier struct {
+	keyID
+	key ed25519.PublicKey
+}
+
+func (v *ed25519Verifier) PublicKey() crypto.PublicKey {
+	return v.key
+}
+
+func (v *ed25519Verifier) Algorithm() string {
+	return AlgorithmEd25519
+}
+
+func (v *ed25519Verifier) Verify(signatureHex, dataHex string) error {
+	signatureBytes, dataBytes, err := decodeSignatureAndData(signatureHex, dataHex)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(v.key, dataBytes, signatureBytes) {
+		return ErrVerification
+	}
+	return nil
+}
+
+type ecdsaSigner struct {
+	signerVerifier
+	key *ecdsa.PrivateKey
+}
+
+func (s *ecdsaSigner) Algorithm() string {
+	return AlgorithmECDSAP256
+}
+
+func (s *ecdsaSigner) Sign(dataHex string) (string, error) {
+	dataBytes, err := hex.DecodeString(dataHex)
+	if err != nil {
+		return "", err
+	}
+
+	signatureBytes, err := ecdsa.SignASN1(rand.Reader, s.key, dataBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(signatureBytes), nil
+}
+
+type ecdsaVerifier struct {
+	keyID
+	key *ecdsa.PublicKey
+}
+
+func (v *ecdsaVerifier) PublicKey() crypto.PublicKey {
+	return v.key
+}
+
+func (v *ecdsaVerifier) Algorithm() string {
+	return AlgorithmECDSAP256
+}
+
+func (v *ecdsaVerifier) Verify(signatureHex, dataHex string) error {
+	signatureBytes, dataBytes, err := decodeSignatureAndData(signatureHex, dataHex)
+	if err != nil {
+		return err
+	}
+
+	if !ecdsa.VerifyASN1(v.key, dataBytes, signatureBytes) {
+		return ErrVerification
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"testing"
+)
+
+func encodePEM(blockType string, der []byte) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}))
+}
+
+func pkcs8(t *testing.T, key crypto.PrivateKey) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encodePEM("PRIVATE KEY", der)
+}
+
+func pkix(t *testing.T, key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encodePEM("PUBLIC KEY", der)
+}
+
+func digest(data string) string {
+	hash := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(hash[:])
+}
+
+type testKeys struct {
+	name      string
+	algorithm string
+	// PEM encodings of the same key pair
+	private []string
+	public  []string
+}
+
+func generateKeys(t *testing.T) []testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDer, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []testKeys{
+		{
+			name:      "rsa",
+			algorithm: AlgorithmRSAPSS,
+			private:   []string{pkcs8(t, rsaKey), encodePEM("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))},
+			public:    []string{pkix(t, &rsaKey.PublicKey), encodePEM("RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey))},
+		},
+		{
+			name:      "ed25519",
+			algorithm: AlgorithmEd25519,
+			private:   []string{pkcs8(t, edPrivate)},
+			public:    []string{pkix(t, edPublic)},
+		},
+		{
+			name:      "ecdsa",
+			algorithm: AlgorithmECDSAP256,
+			private:   []string{pkcs8(t, ecKey), encodePEM("EC PRIVATE KEY", ecDer)},
+			public:    []string{pkix(t, &ecKey.PublicKey)},
+		},
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	keys := generateKeys(t)
+	for idx, key := range keys {
+		t.Run(key.name, func(t *testing.T) {
+			other := keys[(idx+1)%len(keys)]
+			otherVerifier, err := LoadVerifier(other.public[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, privatePEM := range key.private {
+				signer, err := LoadSigner(privatePEM)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if signer.Algorithm() != key.algorithm {
+					t.Fatalf("algorithm is %s, expected %s", signer.Algorithm(), key.algorithm)
+				}
+				signed, err := signer.Sign(digest("data"))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for _, publicPEM := range key.public {
+					verifier, err := LoadVerifier(publicPEM)
+					if err != nil {
+						t.Fatal(err)
+					}
+					// The key ID doesn't depend on the encoding
+					if verifier.KeyID() != signer.KeyID() || verifier.Algorithm() != key.algorithm {
+						t.Fatalf("verifier %s %s of signer %s", verifier.KeyID(), verifier.Algorithm(), signer.KeyID())
+					}
+					if err := verifier.Verify(signed, digest("data")); err != nil {
+						t.Fatal(err)
+					}
+					if err := verifier.Verify(signed, digest("other data")); err == nil {
+						t.Fatal("signature of other data is valid")
+					}
+				}
+				if err := otherVerifier.Verify(signed, digest("data")); err == nil {
+					t.Fatal("signature is valid with another key")
+				}
+			}
+		})
+	}
+}
+
+func TestUnsupportedKeys(t *testing.T) {
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadVerifier(pkix(t, &p384.PublicKey)); err != ErrUnsupportedCurve {
+		t.Fatalf("P-384 key: %v", err)
+	}
+	if _, err := LoadSigner("not a key"); err != ErrNoPEMBlock {
+		t.Fatalf("key without PEM: %v", err)
+	}
+	if _, err := LoadSigner(encodePEM("PRIVATE KEY", []byte("garbage"))); err != ErrUnableToParseKey {
+		t.Fatalf("garbage key: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"lsm-verification/fakedb"
+)
+
+const defaultReplicaID = 2
+
+func main() {
+	addr, exists := os.LookupEnv("dbServerAddress")
+	if !exists {
+		log.Fatalln("Env variable 'dbServerAddress' not found")
+	}
+
+	var replicaId int32 = defaultReplicaID
+	if replicaIdString, exists := os.LookupEnv("dbReplicaID"); exists {
+		parsed, err := strconv.Atoi(replicaIdString)
+		if err != nil {
+			log.Fatalln("Failed to convert replica id")
+		}
+		replicaId = int32(parsed)
+	}
+
+	log.Printf("Starting a fake database on %s with replica id %d\n", addr, replicaId)
+	grpcServer, err := fakedb.ListenAndServe(addr, fakedb.NewServer(replicaId))
+	if err != nil {
+		log.Fatalln("Failed to listen", err)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	log.Println("Stopping the fake database")
+	grpcServer.GracefulStop()
+}
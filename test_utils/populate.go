@@ -6,6 +6,7 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"strconv"
 
 	"lsm-verification/models"
 	"lsm-verification/proto"
@@ -30,9 +31,9 @@ func put(client proto.LSeqDatabaseClient, key, value string) error {
 	return nil
 }
 
-func readBatch(d proto.LSeqDatabaseClient) ([]models.DbItem, error) {
+func readBatch(d proto.LSeqDatabaseClient, replicaId int32) ([]models.DbItem, error) {
 	eventsRequest := &proto.EventsRequest{
-		ReplicaId: 2,
+		ReplicaId: replicaId,
 	}
 
 	log.Println("Requesting a DBItem batch from the database")
@@ -80,6 +81,15 @@ func main() {
 		log.Fatalln("Env variable 'dbServerAddress' not found")
 	}
 
+	var replicaId int32 = 2
+	if replicaIdString, exists := os.LookupEnv("dbReplicaID"); exists {
+		parsed, err := strconv.Atoi(replicaIdString)
+		if err != nil {
+			log.Fatalln("Failed to convert replica id")
+		}
+		replicaId = int32(parsed)
+	}
+
 	log.Println("Dialing GRPC")
 	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
@@ -106,7 +116,7 @@ func main() {
 			put(client, key, value)
 		}
 	} else if mode == "read" {
-		b, err := readBatch(client)
+		b, err := readBatch(client, replicaId)
 		if err != nil {
 			log.Fatalln(err)
 		}
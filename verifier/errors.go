@@ -0,0 +1,5 @@
+package verifier
+
+import "errors"
+
+var ErrReplicaMismatch = errors.New("verifier state belongs to another replica")
@@ -0,0 +1,85 @@
+package verifier
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"lsm-verification/models"
+)
+
+// Last record verified by a validation run, later runs resume from it and
+// check that the history of the database still passes through it
+type State struct {
+	ReplicaID  int32  `json:"replica_id"`
+	Lseq       string `json:"lseq"`
+	Hash       string `json:"hash"`
+	Signature  string `json:"signature"`
+	KeyID      string `json:"key_id,omitempty"`
+	VerifiedAt string `json:"verified_at"`
+}
+
+func NewState(replicaId int32, record *models.ValidateItem) *State {
+	return &State{
+		ReplicaID:  replicaId,
+		Lseq:       record.LseqItemValid,
+		Hash:       record.Hash,
+		Signature:  record.Signature,
+		KeyID:      record.KeyID,
+		VerifiedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+func (s *State) Record() *models.ValidateItem {
+	return &models.ValidateItem{
+		LseqItemValid: s.Lseq,
+		Hash:          s.Hash,
+		Signature:     s.Signature,
+		KeyID:         s.KeyID,
+	}
+}
+
+// Nil without a state file, before the first run
+func Load(path string, replicaId int32) (*State, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	if state.ReplicaID != replicaId {
+		return nil, ErrReplicaMismatch
+	}
+	return &state, nil
+}
+
+// Replaces the file atomically, so an interrupted run keeps the previous state
+func Save(path string, state *State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
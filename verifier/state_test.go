@@ -0,0 +1,75 @@
+package verifier
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"lsm-verification/models"
+)
+
+func TestLoadWithoutFile(t *testing.T) {
+	state, err := Load(filepath.Join(t.TempDir(), "state.json"), 1)
+	if err != nil || state != nil {
+		t.Fatalf("state is %v, error %v, expected none", state, err)
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	first := NewState(1, &models.ValidateItem{LseqItemValid: "a", Hash: "hash a", Signature: "signature a", KeyID: "key"})
+	if err := Save(path, first); err != nil {
+		t.Fatal(err)
+	}
+	second := NewState(1, &models.ValidateItem{LseqItemValid: "b", Hash: "hash b", Signature: "signature b"})
+	if err := Save(path, second); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, second) {
+		t.Fatalf("loaded %+v, expected %+v", loaded, second)
+	}
+	if !reflect.DeepEqual(loaded.Record(), &models.ValidateItem{LseqItemValid: "b", Hash: "hash b", Signature: "signature b"}) {
+		t.Fatalf("record of the state is %+v", loaded.Record())
+	}
+	// The temporary file is renamed over the state
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("%d files are left in the state directory, expected 1", len(entries))
+	}
+}
+
+func TestSaveFailureKeepsState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	state := NewState(1, &models.ValidateItem{LseqItemValid: "a", Hash: "hash a"})
+	if err := Save(path, state); err != nil {
+		t.Fatal(err)
+	}
+	// The directory of the temporary file is missing
+	if err := Save(filepath.Join(path, "state.json"), NewState(1, &models.ValidateItem{LseqItemValid: "b"})); err == nil {
+		t.Fatal("saving into a missing directory succeeded")
+	}
+	loaded, err := Load(path, 1)
+	if err != nil || loaded.Lseq != "a" {
+		t.Fatalf("state is %+v, error %v, expected lseq a", loaded, err)
+	}
+}
+
+func TestLoadOtherReplica(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := Save(path, NewState(1, &models.ValidateItem{LseqItemValid: "a"})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path, 2); err != ErrReplicaMismatch {
+		t.Fatalf("error is %v, expected %v", err, ErrReplicaMismatch)
+	}
+}